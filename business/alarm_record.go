@@ -0,0 +1,43 @@
+package business
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"state_monitor/model"
+
+	"github.com/cihub/seelog"
+)
+
+// report_alarm_x 表的写入字段
+var reportAlarmColumns = []string{
+	"job_id", "service_name", "`rule`", "`status`", "severity", "content", "occurrences", "alarm_time",
+	"is_silenced", "silence_id", "send_status", "send_error", "send_topic", "send_partition", "send_offset",
+	"create_time",
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 将报警及其投递结果交给报警记录批量写入器，写入 report_alarm_x 表后完成报警的消息处理凭证；
+// 投递回调中调用，不同步访问 MySQL
+func (this *Pipeline) recordAlarm(obj *alarmRequest, sendStatus int, sendErr, topic string,
+	partition int32, offset int64) {
+	sendErr = truncateRunes(sendErr, model.REPORT_ALARM_SEND_ERROR_MAX_LEN)
+	value := []interface{}{
+		obj.JobID, obj.ServiceName, obj.Rule, obj.Status, obj.Severity, obj.Content, obj.Occurrences, obj.HeartTime,
+		obj.SilenceID > 0, obj.SilenceID, sendStatus, sendErr, topic, partition, offset, time.Now().Unix(),
+	}
+
+	if err := this.alarmWriter.enqueue(value, obj.ticket); err != nil {
+		seelog.Errorf("record alarm err: %v. [%d#%s rule: %s]", err, obj.JobID, obj.ServiceName, obj.Rule)
+	}
+}
+
+// 截取字符串的前 n 个字符，避免超出 varchar 字段长度导致写入失败
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	return string([]rune(s)[:n])
+}
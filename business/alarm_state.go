@@ -0,0 +1,218 @@
+package business
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"state_monitor/config"
+	"state_monitor/model"
+	"state_monitor/model/redis"
+
+	"github.com/cihub/seelog"
+)
+
+// 将报警规则置为报警中，返回是否由正常状态转换为报警状态
+func (this *Pipeline) fireAlarm(obj *alarmRequest) bool {
+	record := &model.AlarmStateRecord{
+		Rule:       obj.Rule,
+		FiringTime: obj.HeartTime,
+		Content:    obj.Content,
+		Severity:   obj.Severity,
+	}
+
+	isChanged, err := this.alarmStateModel.Fire(obj.JobID, obj.ServiceName, record)
+	if err != nil {
+		seelog.Errorf("fire alarm state err: %v", err)
+		return false
+	}
+
+	return isChanged
+}
+
+// 规则触发报警：记录报警状态，经过去重后发送报警消息
+func (this *Pipeline) raiseAlarm(stateObj *ReceiverStateMsg, m map[string]string, finding *alarmFinding) {
+	obj := &alarmRequest{
+		JobID:       stateObj.JobID,
+		ServiceName: stateObj.ServiceName,
+		EnvType:     stateObj.EnvType,
+		Host:        stateObj.Host,
+		HeartTime:   time.Now().Unix(),
+		Rule:        finding.Rule,
+		Status:      model.ALARM_STATUS_FIRING,
+		Severity:    severityOf(m, finding.Rule, stateObj.EnvType),
+		Findings:    []*alarmFinding{finding},
+		Content: fmt.Sprintf("JobID: %d, ServiceName: %s, Msg: %s",
+			stateObj.JobID, stateObj.ServiceName, finding.Message),
+		ticket: stateObj.ticket,
+		state:  stateObj,
+		policy: m,
+	}
+
+	this.fireAlarm(obj)
+	if this.dedupAlarm(obj, m) {
+		this.sendAlarm(obj)
+	}
+	this.escalateAlarm(obj, m)
+}
+
+// 将本次未命中的规则（心跳除外）置为已恢复
+func (this *Pipeline) resolveAlarms(stateObj *ReceiverStateMsg, firing map[string]bool) {
+	records, err := this.alarmStateModel.GetFiring(stateObj.JobID, stateObj.ServiceName)
+	if err != nil {
+		seelog.Errorf("get firing alarm state err: %v", err)
+		return
+	}
+
+	for rule := range records {
+		if rule == model.ALARM_RULE_HEARTBEAT || firing[rule] {
+			continue
+		}
+		this.resolveAlarm(stateObj, rule)
+	}
+}
+
+// 将报警规则置为已恢复，状态发生转换时发送恢复消息
+func (this *Pipeline) resolveAlarm(stateObj *ReceiverStateMsg, rule string) {
+	this.resolveRule(&alarmRequest{
+		JobID:       stateObj.JobID,
+		ServiceName: stateObj.ServiceName,
+		EnvType:     stateObj.EnvType,
+		Host:        stateObj.Host,
+		Rule:        rule,
+		ticket:      stateObj.ticket,
+		state:       stateObj,
+	})
+}
+
+// 将 obj 的规则置为已恢复，obj 只需包含服务信息和规则，状态发生转换时补全为恢复消息并发送
+func (this *Pipeline) resolveRule(obj *alarmRequest) {
+	jobId, serviceName, rule := obj.JobID, obj.ServiceName, obj.Rule
+	record, err := this.alarmStateModel.Resolve(jobId, serviceName, rule)
+	if err != nil {
+		seelog.Errorf("resolve alarm state err: %v", err)
+		return
+	}
+	if record == nil {
+		return
+	}
+
+	suppressed, err := this.alarmDedupModel.Reset(jobId, serviceName, rule)
+	if err != nil {
+		seelog.Errorf("reset alarm dedup err: %v", err)
+	}
+
+	msg := fmt.Sprintf("%s alarm resolved", rule)
+	if suppressed > 0 {
+		msg = fmt.Sprintf("%s, %d occurrences suppressed since the last alarm", msg, suppressed)
+		obj.Occurrences = suppressed
+	}
+	obj.HeartTime = time.Now().Unix()
+	obj.FiringTime = record.FiringTime
+	obj.Status = model.ALARM_STATUS_RESOLVED
+	obj.Severity = record.Severity
+	obj.Content = fmt.Sprintf("JobID: %d, ServiceName: %s, Msg: %s", jobId, serviceName, msg)
+	this.sendAlarm(obj)
+}
+
+// 报警去重：抑制窗口内的重复报警不发送，窗口结束时由 sweepDigest 发送包含累计次数的汇总报警，
+// 汇总之前再次报警时直接发送并附带累计次数
+func (this *Pipeline) dedupAlarm(obj *alarmRequest, m map[string]string) bool {
+	window := int64(config.GetConfig().Service.AlarmSuppressWindow)
+	if v, ok := m["suppress_window"]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err != nil {
+			seelog.Warnf("suppress_window %q of [%d#%s] is invalid, use %ds", v, obj.JobID, obj.ServiceName, window)
+		} else {
+			window = n
+		}
+	}
+
+	alarm, err := json.Marshal(obj)
+	if err != nil {
+		seelog.Errorf("json marshal alarm err: %v", err)
+	}
+	isAllow, count, err := this.alarmDedupModel.Allow(obj.JobID, obj.ServiceName, obj.Rule, window, alarm)
+	if err != nil {
+		seelog.Errorf("dedup alarm err: %v", err)
+		return true
+	}
+	if !isAllow {
+		return false
+	}
+
+	if count > 1 {
+		obj.Occurrences = count
+		obj.Content = fmt.Sprintf("%s (still firing, %d occurrences)", obj.Content, count)
+	}
+
+	return true
+}
+
+// 定期为抑制窗口已结束的报警发送汇总报警
+func (this *Pipeline) sweepDigest() {
+	defer this.wg.Done()
+
+	interval := model.ALARM_DIGEST_SWEEP_INTERVAL * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.chanExit:
+			return
+		case <-ticker.C:
+			// 多个消费者共享同一份去重记录，每个扫描周期只允许一个消费者执行
+			locked, err := redis.TryLock(model.RDS_REPORT_STATE_DEDUP, int(interval/time.Millisecond))
+			if err != nil {
+				seelog.Errorf("lock alarm digest sweep err: %v", err)
+				continue
+			}
+			if locked {
+				this.sendDigests()
+			}
+		}
+	}
+}
+
+func (this *Pipeline) sendDigests() {
+	now := time.Now().Unix()
+	due, err := this.alarmDedupModel.Due(now)
+	if err != nil {
+		seelog.Errorf("get due alarm digests err: %v", err)
+		return
+	}
+
+	for _, key := range due {
+		digest, err := this.alarmDedupModel.Digest(key)
+		if err != nil {
+			seelog.Errorf("get alarm digest %s err: %v", key, err)
+			continue
+		}
+		if digest == nil {
+			continue
+		}
+
+		obj := &alarmRequest{}
+		if err = json.Unmarshal(digest.Alarm, obj); err != nil {
+			seelog.Errorf("json unmarshal alarm digest %s err: %v", key, err)
+			continue
+		}
+
+		// 期间已恢复的规则不再汇总
+		record, err := this.alarmStateModel.Get(obj.JobID, obj.ServiceName, obj.Rule)
+		if err != nil || record == nil {
+			continue
+		}
+
+		m, err := this.monitorPolicyModel.GetFieldsMap(obj.JobID, obj.ServiceName, obj.EnvType)
+		if err != nil {
+			seelog.Errorf("get state monitor policy fields err: %v", err)
+		}
+		obj.policy = m
+		obj.HeartTime = now
+		obj.Occurrences = digest.Occurrences
+		obj.Content = fmt.Sprintf("%s (still firing, %d occurrences)", obj.Content, digest.Occurrences)
+		this.sendAlarm(obj)
+	}
+}
@@ -0,0 +1,243 @@
+package business
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"state_monitor/config"
+	"state_monitor/model"
+
+	"github.com/cihub/seelog"
+)
+
+// 报警内容模板（text/template）：按 模板名称+规则、模板名称、默认模板+规则、默认模板 的顺序查找，
+// 都没有时使用内置的报警内容，渲染失败时同样回退到内置内容
+type alarmTemplates struct {
+	model  *model.StateMonitorAlarmTemplate // 报警内容模板模型
+	l      sync.Mutex                       // 锁
+	parsed map[string]*template.Template    // 按模板内容缓存解析结果，解析失败为 nil
+}
+
+// 模板数据
+type alarmTemplateData struct {
+	Alarm   *alarmRequest     // 报警，Content 为内置的报警内容
+	State   *ReceiverStateMsg // 触发报警的上报状态，心跳超时报警为 nil
+	Policy  map[string]string // 监控策略，恢复报警为 nil
+	Finding *alarmFinding     // 触发报警的规则结果，恢复报警为 nil
+}
+
+// 模板可以使用的辅助函数
+var alarmTemplateFuncs = template.FuncMap{
+	"humanBytes":    humanBytes,
+	"humanDuration": humanDuration,
+	"formatTime":    formatTime,
+	"since":         since,
+	"envName":       envName,
+	"upper":         strings.ToUpper,
+	"lower":         strings.ToLower,
+	"trim":          strings.TrimSpace,
+	"join":          strings.Join,
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 解析并试渲染配置文件中的模板，模板有误时返回错误
+func newAlarmTemplates() (*alarmTemplates, error) {
+	t := &alarmTemplates{
+		model:  model.NewStateMonitorAlarmTemplate(),
+		parsed: make(map[string]*template.Template),
+	}
+
+	for _, tpl := range config.GetConfig().AlarmTemplates {
+		parsed, err := parseAlarmTemplate(tpl.Text)
+		if err != nil {
+			return nil, fmt.Errorf("alarm template [name: %q, rule: %q] is invalid: %v", tpl.Name, tpl.Rule, err)
+		}
+		t.parsed[tpl.Text] = parsed
+	}
+
+	return t, nil
+}
+
+// 获取所有模板，获取数据库中的模板失败时只使用配置文件中的模板
+func (this *alarmTemplates) load() map[string]*model.AlarmTemplateRecord {
+	records, err := this.model.GetAll()
+	if err != nil {
+		seelog.Errorf("get alarm templates err: %v", err)
+	}
+
+	return records
+}
+
+// 使用指定名称的模板渲染报警内容
+func (this *alarmTemplates) render(records map[string]*model.AlarmTemplateRecord, name string,
+	obj *alarmRequest) string {
+
+	record := this.lookup(records, name, obj.Rule)
+	if record == nil {
+		return obj.Content
+	}
+	tpl := this.parse(record)
+	if tpl == nil {
+		return obj.Content
+	}
+
+	data := &alarmTemplateData{
+		Alarm:  obj,
+		State:  obj.state,
+		Policy: obj.policy,
+	}
+	if len(obj.Findings) > 0 {
+		data.Finding = obj.Findings[0]
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		seelog.Errorf("render alarm template [name: %s, rule: %s] err: %v", record.Name, record.Rule, err)
+		return obj.Content
+	}
+
+	return strings.TrimSpace(buf.String())
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *alarmTemplates) lookup(records map[string]*model.AlarmTemplateRecord, name,
+	rule string) *model.AlarmTemplateRecord {
+
+	keys := []string{model.AlarmTemplateKey(name, rule), model.AlarmTemplateKey(name, "")}
+	if name != "" {
+		keys = append(keys, model.AlarmTemplateKey("", rule), model.AlarmTemplateKey("", ""))
+	}
+	for _, key := range keys {
+		if record, ok := records[key]; ok {
+			return record
+		}
+	}
+
+	return nil
+}
+
+// 解析模板，数据库中的模板有误时只记录一次错误
+func (this *alarmTemplates) parse(record *model.AlarmTemplateRecord) *template.Template {
+	this.l.Lock()
+	defer this.l.Unlock()
+
+	if tpl, ok := this.parsed[record.Text]; ok {
+		return tpl
+	}
+
+	tpl, err := parseAlarmTemplate(record.Text)
+	if err != nil {
+		seelog.Errorf("alarm template [name: %s, rule: %s] is invalid: %v", record.Name, record.Rule, err)
+	}
+	this.parsed[record.Text] = tpl
+
+	return tpl
+}
+
+// 解析模板并用示例数据试渲染，提前发现语法错误和不存在的字段。除了规则报警，还要用恢复报警的数据
+// （State、Policy、Finding 均为 nil，没有 Findings，也覆盖了心跳超时报警 State 为 nil 的情况）试渲染，
+// 避免未用 with/if 判断的模板在这些报警上渲染失败
+func parseAlarmTemplate(text string) (*template.Template, error) {
+	tpl, err := template.New("alarm").Funcs(alarmTemplateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	firing := &alarmTemplateData{
+		Alarm:   &alarmRequest{Findings: []*alarmFinding{{}}},
+		State:   &ReceiverStateMsg{},
+		Policy:  map[string]string{},
+		Finding: &alarmFinding{},
+	}
+	if err = tpl.Execute(ioutil.Discard, firing); err != nil {
+		return nil, err
+	}
+
+	resolved := &alarmTemplateData{
+		Alarm: &alarmRequest{Status: model.ALARM_STATUS_RESOLVED},
+	}
+	if err = tpl.Execute(ioutil.Discard, resolved); err != nil {
+		return nil, fmt.Errorf("%v (State, Policy and Finding are nil for resolved alarms, State is nil for "+
+			"heartbeat alarms, use with or if)", err)
+	}
+
+	return tpl, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 字节数转换为 KB/MB/GB 等
+func humanBytes(v interface{}) string {
+	n := toFloat(v)
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+	i := 0
+	for ; n >= 1024 && i < len(units)-1; i++ {
+		n /= 1024
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+// 秒数转换为 1h2m3s
+func humanDuration(v interface{}) string {
+	return (time.Duration(toFloat(v)) * time.Second).String()
+}
+
+// 时间戳（秒）转换为 2006-01-02 15:04:05
+func formatTime(v interface{}) string {
+	return time.Unix(int64(toFloat(v)), 0).Format("2006-01-02 15:04:05")
+}
+
+// 时间戳（秒）距今的时长
+func since(v interface{}) string {
+	return humanDuration(time.Now().Unix() - int64(toFloat(v)))
+}
+
+// 环境类型转换为 dev/test/ide/pre/pro
+func envName(envType int) string {
+	switch envType {
+	case model.REPORT_STATE_COM_ENV_TYPE_DEV:
+		return "dev"
+	case model.REPORT_STATE_COM_ENV_TYPE_TEST:
+		return "test"
+	case model.REPORT_STATE_COM_ENV_TYPE_IDE:
+		return "ide"
+	case model.REPORT_STATE_COM_ENV_TYPE_PRE:
+		return "pre"
+	case model.REPORT_STATE_COM_ENV_TYPE_PRO:
+		return "pro"
+	}
+	return strconv.Itoa(envType)
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f
+	}
+	return 0
+}
@@ -0,0 +1,215 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cihub/seelog"
+)
+
+// 按月滚动的表，批量写入时表不存在则建表
+type rollingTable interface {
+	RollingBatchInsert(columns []string, params []interface{}) (int64, error)
+}
+
+type batchRow struct {
+	value  []interface{} // 写入的字段值
+	ticket *ticket       // 消息处理凭证，写入 MySQL 后完成
+}
+
+// 批量写入器：调用方将数据写入通道，写入协程按条数或定时批量写入 MySQL，写入失败的数据保留并重试。
+// 写入协程的生命周期绑定服务的 context，context 结束时写入剩余数据后退出
+type batchWriter struct {
+	name      string              // 写入的数据名称，用于日志
+	columns   []string            // 写入字段
+	newTable  func() rollingTable // 创建表模型，每个写入协程使用独立的模型
+	ctx       context.Context     // 写入协程的 context
+	cancel    context.CancelFunc  // 结束写入协程
+	chanRows  chan *batchRow      // 待写入数据通道
+	chanFlush chan struct{}       // 立即写入消息通道
+	wg        sync.WaitGroup      // 等待写入协程退出
+	size      int                 // 批量写入条数
+	interval  time.Duration       // 定时写入间隔，数据最迟在该间隔内写入
+	writers   int                 // 写入协程数
+
+	// 反压统计，原子操作
+	enqueued    int64 // 写入通道的总条数
+	blocked     int64 // 通道已满、需要等待的次数
+	blockedTime int64 // 通道已满时累计的等待时长，单位纳秒
+	flushed     int64 // 成功写入 MySQL 的总条数
+	failed      int64 // 批量写入失败的次数
+}
+
+type batchWriterStats struct {
+	Queued      int           // 通道中待写入的条数
+	Enqueued    int64         // 写入通道的总条数
+	Blocked     int64         // 通道已满、需要等待的次数
+	BlockedTime time.Duration // 通道已满时累计的等待时长
+	Flushed     int64         // 成功写入 MySQL 的总条数
+	Failed      int64         // 批量写入失败的次数
+}
+
+var errBatchWriterStopped = errors.New("batch writer is stopped")
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newBatchWriter(name string, columns []string, newTable func() rollingTable,
+	size, queueSize, writers int, interval time.Duration) *batchWriter {
+	return &batchWriter{
+		name:      name,
+		columns:   columns,
+		newTable:  newTable,
+		chanRows:  make(chan *batchRow, queueSize),
+		chanFlush: make(chan struct{}, writers),
+		size:      size,
+		interval:  interval,
+		writers:   writers,
+	}
+}
+
+func (this *batchWriter) start(ctx context.Context) {
+	this.ctx, this.cancel = context.WithCancel(ctx)
+	for i := 0; i < this.writers; i++ {
+		this.wg.Add(1)
+		go this.write()
+	}
+}
+
+// 停止写入协程，等待通道中剩余的数据写入 MySQL
+func (this *batchWriter) stop() {
+	if this.cancel != nil {
+		this.cancel()
+	}
+	this.wg.Wait()
+}
+
+// 写入一条数据，写入 MySQL 后完成消息处理凭证，凭证可以为 nil；通道已满时阻塞等待写入协程消费
+func (this *batchWriter) enqueue(value []interface{}, t *ticket) error {
+	row := &batchRow{value: value, ticket: t}
+
+	row.ticket.add(1)
+	select {
+	case this.chanRows <- row:
+		atomic.AddInt64(&this.enqueued, 1)
+		return nil
+	default:
+	}
+
+	// back pressure, wait for writers
+	begin := time.Now()
+	atomic.AddInt64(&this.blocked, 1)
+	defer func() {
+		atomic.AddInt64(&this.blockedTime, int64(time.Since(begin)))
+	}()
+
+	select {
+	case this.chanRows <- row:
+		atomic.AddInt64(&this.enqueued, 1)
+		return nil
+	case <-this.ctx.Done():
+		row.ticket.done()
+		return errBatchWriterStopped
+	}
+}
+
+// 通知所有写入协程立即写入，不等待写入间隔
+func (this *batchWriter) flush() {
+	for i := 0; i < this.writers; i++ {
+		select {
+		case this.chanFlush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (this *batchWriter) stats() batchWriterStats {
+	return batchWriterStats{
+		Queued:      len(this.chanRows),
+		Enqueued:    atomic.LoadInt64(&this.enqueued),
+		Blocked:     atomic.LoadInt64(&this.blocked),
+		BlockedTime: time.Duration(atomic.LoadInt64(&this.blockedTime)),
+		Flushed:     atomic.LoadInt64(&this.flushed),
+		Failed:      atomic.LoadInt64(&this.failed),
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *batchWriter) write() {
+	defer this.wg.Done()
+
+	// RollingBatchInsert 会修改表名，每个写入协程使用独立的模型
+	table := this.newTable()
+	rows := make([]*batchRow, 0, this.size)
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+
+	var lastBlocked int64
+	for {
+		// 缓存已满（写入失败）时不再读取通道，由通道向消费者反压
+		in := this.chanRows
+		if len(rows) >= this.size {
+			in = nil
+		}
+
+		select {
+		case row := <-in:
+			rows = append(rows, row)
+			if len(rows) >= this.size {
+				rows = this.insert(table, rows)
+			}
+		case <-ticker.C:
+			rows = this.insert(table, rows)
+			if stats := this.stats(); stats.Blocked > lastBlocked {
+				lastBlocked = stats.Blocked
+				seelog.Warnf("%s batch writer back pressure: %+v", this.name, stats)
+			}
+		case <-this.chanFlush:
+			rows = this.insert(table, this.drain(rows))
+		case <-this.ctx.Done():
+			if rows = this.insert(table, this.drain(rows)); len(rows) > 0 {
+				seelog.Errorf("%s batch writer exit with %d rows unwritten", this.name, len(rows))
+			}
+			return
+		}
+	}
+}
+
+// 读取通道中已有的全部数据
+func (this *batchWriter) drain(rows []*batchRow) []*batchRow {
+	for {
+		select {
+		case row := <-this.chanRows:
+			rows = append(rows, row)
+		default:
+			return rows
+		}
+	}
+}
+
+// 批量写入 MySQL，写入成功后完成对应的消息处理凭证，返回未写入的数据
+func (this *batchWriter) insert(table rollingTable, rows []*batchRow) []*batchRow {
+	if len(rows) == 0 {
+		return rows
+	}
+
+	values := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		values = append(values, row.value)
+	}
+	if _, err := table.RollingBatchInsert(this.columns, values); err != nil {
+		atomic.AddInt64(&this.failed, 1)
+		seelog.Errorf("batch insert %d %s rows err: %v", len(rows), this.name, err)
+		return rows
+	}
+	atomic.AddInt64(&this.flushed, int64(len(rows)))
+
+	for _, row := range rows {
+		row.ticket.done()
+	}
+
+	return rows[0:0]
+}
@@ -1,8 +1,17 @@
 package business
 
+import (
+	"context"
+)
+
+// 消息来源：接收上报状态并提交到 Pipeline。Stop 停止接收新消息，等待接收中的消息（最多到 ctx 结束）；
+// Close 在流水线停止后调用，等待已接收的消息完成（或 ctx 结束），提交消费进度并释放资源；
+// Pending 为已接收但未完成的消息数
 type Consumer interface {
-	Start() error
-	Stop() error
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+	Close(ctx context.Context) error
+	Pending() int
 }
 
 type ReceiverStateMsg struct {
@@ -22,11 +31,38 @@ type ReceiverStateMsg struct {
 	NetOut      int64  `json:"net_out"`
 	Extend      string `json:"extend"`
 	IsAlarm     bool   `json:"-"`
+
+	ticket *ticket // 消息处理凭证
 }
 
 type alarmRequest struct {
-	JobID       int64  `json:"job_id"`
-	ServiceName string `json:"service_name"`
-	HeartTime   int64  `json:"heart_time"`
-	Content     string `json:"content"`
+	JobID       int64           `json:"job_id"`
+	ServiceName string          `json:"service_name"`
+	EnvType     int             `json:"env_type"`
+	Host        string          `json:"host,omitempty"`
+	HeartTime   int64           `json:"heart_time"`
+	FiringTime  int64           `json:"firing_time,omitempty"`
+	Rule        string          `json:"rule,omitempty"`
+	Status      string          `json:"status"`
+	Severity    string          `json:"severity,omitempty"`
+	Escalated   bool            `json:"escalated,omitempty"`
+	Occurrences int64           `json:"occurrences,omitempty"`
+	Findings    []*alarmFinding `json:"findings,omitempty"`
+	Content     string          `json:"content"`
+	Team        string          `json:"team,omitempty"`
+	Contacts    []string        `json:"contacts,omitempty"`
+	Channels    []string        `json:"channels,omitempty"`
+	SilenceID   int64           `json:"-"`
+
+	ticket *ticket           // 触发报警的消息处理凭证，心跳扫描产生的报警为 nil
+	state  *ReceiverStateMsg // 触发报警的上报状态，供报警内容模板使用，心跳扫描产生的报警为 nil
+	policy map[string]string // 监控策略，供报警内容模板使用
+}
+
+type alarmFinding struct {
+	Rule      string `json:"rule"`
+	Message   string `json:"message"`
+	Value     string `json:"value,omitempty"`
+	Threshold string `json:"threshold,omitempty"`
+	Expr      string `json:"expr,omitempty"`
 }
@@ -0,0 +1,134 @@
+package business
+
+import (
+	"strconv"
+	"time"
+
+	"state_monitor/config"
+	"state_monitor/model"
+
+	"github.com/Shopify/sarama"
+	"github.com/bsm/sarama-cluster"
+	"github.com/cihub/seelog"
+)
+
+// 死信队列：无法解析或被拒绝的上报消息投递到死信主题，消息头记录原始位置和失败原因
+type deadLetterQueue struct {
+	producer *kafkaProducer // 共用的生产者
+	topic    string         // 死信主题
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newDeadLetterQueue(producer *kafkaProducer, topic string) *deadLetterQueue {
+	return &deadLetterQueue{
+		producer: producer,
+		topic:    topic,
+	}
+}
+
+// 将消息投递到死信主题，投递确认后完成消息处理凭证；死信队列为 nil 时只记录日志
+func (this *deadLetterQueue) send(t *ticket, reason error) {
+	seelog.Errorf("reject state msg [%s M:%s], err: %v", t, string(t.value), reason)
+
+	if this == nil {
+		return
+	}
+
+	t.add(1)
+	msg := &sarama.ProducerMessage{
+		Topic: this.topic,
+		Key:   sarama.ByteEncoder(t.key),
+		Value: sarama.ByteEncoder(t.value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(model.DEAD_LETTER_HEADER_TOPIC), Value: []byte(t.source)},
+			{Key: []byte(model.DEAD_LETTER_HEADER_PARTITION), Value: []byte(strconv.Itoa(int(t.partition)))},
+			{Key: []byte(model.DEAD_LETTER_HEADER_OFFSET), Value: []byte(strconv.FormatInt(t.offset, 10))},
+			{Key: []byte(model.DEAD_LETTER_HEADER_ERROR), Value: []byte(reason.Error())},
+			{Key: []byte(model.DEAD_LETTER_HEADER_TIME), Value: []byte(strconv.FormatInt(time.Now().Unix(), 10))},
+		},
+	}
+	this.producer.send(msg, "dead letter "+t.String(), func(msg *sarama.ProducerMessage, err error) {
+		if err != nil {
+			seelog.Errorf("send dead letter failed, msg lost. [%s] reason: %v", t, reason)
+		}
+		t.done()
+	})
+}
+
+// 将死信主题中的消息重新投递到原始主题（原始主题不在 targets 中时投递到 targets[0]），
+// 连续 idle 时间没有新消息时结束，返回重新投递的消息数
+func ReplayDeadLetter(cfg *config.Kafka, targets []string, idle time.Duration) (int, error) {
+	produceConfig := sarama.NewConfig()
+	if err := applyKafkaConfig(produceConfig, cfg); err != nil {
+		return 0, err
+	}
+	produceConfig.Producer.Return.Successes = true
+	producer, err := sarama.NewSyncProducer(cfg.Brokers, produceConfig)
+	if err != nil {
+		return 0, err
+	}
+	defer producer.Close()
+
+	groupId := "state_monitor_dead_letter_replay"
+	consumerConfig := cluster.NewConfig()
+	if err = applyKafkaConfig(&consumerConfig.Config, cfg); err != nil {
+		return 0, err
+	}
+	consumerConfig.Consumer.Return.Errors = true
+	consumerConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	consumer, err := cluster.NewConsumer(cfg.Brokers, groupId, []string{cfg.DeadLetterTopic}, consumerConfig)
+	if err != nil {
+		return 0, err
+	}
+	defer consumer.Close()
+
+	count := 0
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return count, nil
+		case err = <-consumer.Errors():
+			if err != nil {
+				seelog.Errorf("dead letter consumer err: %v", err)
+			}
+		case msg := <-consumer.Messages():
+			topic := targets[0]
+			for _, header := range msg.Headers {
+				if string(header.Key) != model.DEAD_LETTER_HEADER_TOPIC {
+					continue
+				}
+				for _, target := range targets {
+					if target == string(header.Value) {
+						topic = target
+					}
+				}
+			}
+
+			_, _, err = producer.SendMessage(&sarama.ProducerMessage{
+				Topic: topic,
+				Key:   sarama.ByteEncoder(msg.Key),
+				Value: sarama.ByteEncoder(msg.Value),
+			})
+			if err != nil {
+				return count, err
+			}
+			consumer.MarkOffset(msg, "")
+			count++
+
+			seelog.Infof("replay dead letter [T:%s P:%d O:%d] to %s", msg.Topic, msg.Partition, msg.Offset, topic)
+
+			// drain without blocking, since go 1.23 a stopped timer's channel holds no stale value
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(idle)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package business
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"state_monitor/business/notify"
+)
+
+// 邮件投递目标：主题包含报警级别、状态、服务和规则，正文为报警内容和报警 JSON
+func newEmailSink(host string, port int, user, password, from string, to []string, retries int,
+	timeout time.Duration) *asyncSink {
+
+	mail := notify.NewMail(host, port, user, password, from, to, timeout)
+
+	return newAsyncSink("email:"+mail.Addr(), strings.Join(to, ","), retries, 1,
+		func(obj *alarmRequest, value []byte) error {
+			subject := fmt.Sprintf("[%s][%s] %s %s", obj.Severity, obj.Status, obj.ServiceName, obj.Rule)
+			return mail.Send(subject, fmt.Sprintf("%s\r\n\r\n%s", obj.Content, value))
+		})
+}
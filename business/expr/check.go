@@ -0,0 +1,147 @@
+package expr
+
+import (
+	"fmt"
+)
+
+// 值的类型，编译时据此校验操作数，避免规则在求值时才发现永远无法得到结果
+type Type int
+
+const (
+	Any    Type = iota // 类型未知，求值时校验
+	Number             // 数字
+	String             // 字符串
+	Bool               // 布尔值
+)
+
+// 返回变量的类型，变量不存在时返回 false
+type TypeOf func(name string) (Type, bool)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this Type) String() string {
+	switch this {
+	case Number:
+		return "number"
+	case String:
+		return "string"
+	case Bool:
+		return "bool"
+	}
+	return "any"
+}
+
+// 两个类型都已知时才能判断是否一致
+func (this Type) conflicts(t Type) bool {
+	return this != Any && t != Any && this != t
+}
+
+func (this Type) is(t Type) bool {
+	return this == Any || this == t
+}
+
+func typeOfValue(value interface{}) Type {
+	switch value.(type) {
+	case float64:
+		return Number
+	case string:
+		return String
+	case bool:
+		return Bool
+	}
+	return Any
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *literal) check(typeOf TypeOf) (Type, error) {
+	return typeOfValue(this.value), nil
+}
+
+func (this *variable) check(typeOf TypeOf) (Type, error) {
+	if typeOf == nil {
+		return Any, nil
+	}
+	t, ok := typeOf(this.name)
+	if !ok {
+		return Any, fmt.Errorf("unknown field %q", this.name)
+	}
+	return t, nil
+}
+
+func (this *unary) check(typeOf TypeOf) (Type, error) {
+	t, err := this.x.check(typeOf)
+	if err != nil {
+		return Any, err
+	}
+
+	switch this.op {
+	case "!":
+		if !t.is(Bool) {
+			return Any, fmt.Errorf("operator ! needs bool, got %s", t)
+		}
+		return Bool, nil
+	case "-":
+		if !t.is(Number) {
+			return Any, fmt.Errorf("operator - needs number, got %s", t)
+		}
+		return Number, nil
+	}
+
+	return Any, fmt.Errorf("unknown operator %s", this.op)
+}
+
+func (this *binary) check(typeOf TypeOf) (Type, error) {
+	x, err := this.x.check(typeOf)
+	if err != nil {
+		return Any, err
+	}
+	y, err := this.y.check(typeOf)
+	if err != nil {
+		return Any, err
+	}
+
+	switch this.op {
+	case "&&", "||":
+		if !x.is(Bool) || !y.is(Bool) {
+			return Any, fmt.Errorf("operator %s needs bool, got %s and %s", this.op, x, y)
+		}
+		return Bool, nil
+	case "==", "!=":
+		if x.conflicts(y) {
+			return Any, fmt.Errorf("operator %s can not compare %s with %s", this.op, x, y)
+		}
+		return Bool, nil
+	case ">", ">=", "<", "<=":
+		if x == Bool || y == Bool || x.conflicts(y) {
+			return Any, fmt.Errorf("operator %s can not compare %s with %s", this.op, x, y)
+		}
+		return Bool, nil
+	case "+", "-", "*", "/", "%":
+		if !x.is(Number) || !y.is(Number) {
+			return Any, fmt.Errorf("operator %s needs numbers, got %s and %s", this.op, x, y)
+		}
+		return Number, nil
+	}
+
+	return Any, fmt.Errorf("unknown operator %s", this.op)
+}
+
+func (this *inList) check(typeOf TypeOf) (Type, error) {
+	x, err := this.x.check(typeOf)
+	if err != nil {
+		return Any, err
+	}
+
+	for _, n := range this.list {
+		y, err := n.check(typeOf)
+		if err != nil {
+			return Any, err
+		}
+		if x.conflicts(y) {
+			return Any, fmt.Errorf("operator in can not compare %s with %s", x, y)
+		}
+	}
+
+	return Bool, nil
+}
@@ -0,0 +1,299 @@
+// 监控策略的规则表达式：表达式在加载策略时编译并校验一次，之后针对每条上报消息求值
+//
+// 支持数字、字符串、布尔值，算术运算 + - * / %，比较运算 == != > >= < <=，
+// 逻辑运算 && || !（或 and or not），以及集合判断 in (...) / not in (...)，例如：
+//
+//	memory > 80 && load > 70
+//	exit_code in (2, 3)
+//	net_out - prev.net_out > 1e9
+//
+// 编译时校验操作数类型和结果类型，例如 memory + 1、memory > 'a' 无法编译
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+var (
+	ErrNotBool = errors.New("expression result is not bool")
+)
+
+// 表达式求值时获取变量的值，支持 float64、string、bool
+type Env interface {
+	Lookup(name string) (interface{}, bool)
+}
+
+type Expr struct {
+	src  string
+	root node
+	vars []string
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 编译表达式并校验操作数类型，表达式的结果必须为布尔值。typeOf 返回表达式中引用的变量的类型，
+// 为 nil 时不校验变量名，变量的类型在求值时校验
+func Compile(src string, typeOf TypeOf) (*Expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, fmt.Errorf("compile %q err: %v", src, err)
+	}
+
+	p := &parser{
+		tokens: tokens,
+		vars:   make(map[string]bool),
+		typeOf: typeOf,
+	}
+	root, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("compile %q err: %v", src, err)
+	}
+
+	t, err := root.check(typeOf)
+	if err != nil {
+		return nil, fmt.Errorf("compile %q err: %v", src, err)
+	}
+	if !t.is(Bool) {
+		return nil, fmt.Errorf("compile %q err: %v, got %s", src, ErrNotBool, t)
+	}
+
+	vars := make([]string, 0, len(p.vars))
+	for name := range p.vars {
+		vars = append(vars, name)
+	}
+	sort.Strings(vars)
+
+	return &Expr{
+		src:  src,
+		root: root,
+		vars: vars,
+	}, nil
+}
+
+func (this *Expr) String() string {
+	return this.src
+}
+
+// 表达式引用的变量名
+func (this *Expr) Vars() []string {
+	return this.vars
+}
+
+func (this *Expr) Eval(env Env) (interface{}, error) {
+	return this.root.eval(env)
+}
+
+func (this *Expr) EvalBool(env Env) (bool, error) {
+	value, err := this.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := value.(bool)
+	if !ok {
+		return false, ErrNotBool
+	}
+
+	return b, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type node interface {
+	eval(env Env) (interface{}, error)
+	check(typeOf TypeOf) (Type, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+type variable struct {
+	name string
+}
+
+type unary struct {
+	op string
+	x  node
+}
+
+type binary struct {
+	op   string
+	x, y node
+}
+
+type inList struct {
+	x    node
+	list []node
+	not  bool
+}
+
+func (this *literal) eval(env Env) (interface{}, error) {
+	return this.value, nil
+}
+
+func (this *variable) eval(env Env) (interface{}, error) {
+	value, ok := env.Lookup(this.name)
+	if !ok {
+		return nil, fmt.Errorf("field %q is not available", this.name)
+	}
+	return value, nil
+}
+
+func (this *unary) eval(env Env) (interface{}, error) {
+	value, err := this.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch this.op {
+	case "!":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator ! needs bool, got %v", value)
+		}
+		return !b, nil
+	case "-":
+		f, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("operator - needs number, got %v", value)
+		}
+		return -f, nil
+	}
+
+	return nil, fmt.Errorf("unknown operator %s", this.op)
+}
+
+func (this *binary) eval(env Env) (interface{}, error) {
+	x, err := this.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// short-circuit logical operators
+	if this.op == "&&" || this.op == "||" {
+		bx, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s needs bool, got %v", this.op, x)
+		}
+		if (this.op == "&&" && !bx) || (this.op == "||" && bx) {
+			return bx, nil
+		}
+		y, err := this.y.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		by, ok := y.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s needs bool, got %v", this.op, y)
+		}
+		return by, nil
+	}
+
+	y, err := this.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch this.op {
+	case "==":
+		return equal(x, y), nil
+	case "!=":
+		return !equal(x, y), nil
+	case ">", ">=", "<", "<=":
+		return compare(this.op, x, y)
+	}
+
+	fx, okx := x.(float64)
+	fy, oky := y.(float64)
+	if !okx || !oky {
+		return nil, fmt.Errorf("operator %s needs numbers, got %v and %v", this.op, x, y)
+	}
+
+	switch this.op {
+	case "+":
+		return fx + fy, nil
+	case "-":
+		return fx - fy, nil
+	case "*":
+		return fx * fy, nil
+	case "/":
+		if fy == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return fx / fy, nil
+	case "%":
+		if fy == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return math.Mod(fx, fy), nil
+	}
+
+	return nil, fmt.Errorf("unknown operator %s", this.op)
+}
+
+func (this *inList) eval(env Env) (interface{}, error) {
+	x, err := this.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range this.list {
+		y, err := n.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if equal(x, y) {
+			return !this.not, nil
+		}
+	}
+
+	return this.not, nil
+}
+
+func equal(x, y interface{}) bool {
+	return x == y
+}
+
+func compare(op string, x, y interface{}) (bool, error) {
+	var c int
+
+	switch vx := x.(type) {
+	case float64:
+		vy, ok := y.(float64)
+		if !ok {
+			return false, fmt.Errorf("operator %s can not compare %v with %v", op, x, y)
+		}
+		if vx < vy {
+			c = -1
+		} else if vx > vy {
+			c = 1
+		}
+	case string:
+		vy, ok := y.(string)
+		if !ok {
+			return false, fmt.Errorf("operator %s can not compare %v with %v", op, x, y)
+		}
+		if vx < vy {
+			c = -1
+		} else if vx > vy {
+			c = 1
+		}
+	default:
+		return false, fmt.Errorf("operator %s can not compare %v with %v", op, x, y)
+	}
+
+	switch op {
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	case "<":
+		return c < 0, nil
+	default:
+		return c <= 0, nil
+	}
+}
@@ -0,0 +1,187 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+type mapEnv map[string]interface{}
+
+func (this mapEnv) Lookup(name string) (interface{}, bool) {
+	value, ok := this[name]
+	return value, ok
+}
+
+var testTypes = map[string]Type{
+	"memory":         Number,
+	"load":           Number,
+	"exit_code":      Number,
+	"net_out":        Number,
+	"host":           String,
+	"prev.memory":    Number,
+	"prev.net_out":   Number,
+	"prev.exit_code": Number,
+}
+
+func testTypeOf(name string) (Type, bool) {
+	t, ok := testTypes[name]
+	return t, ok
+}
+
+var testEnv = mapEnv{
+	"memory":       float64(85),
+	"load":         float64(40),
+	"exit_code":    float64(2),
+	"net_out":      float64(3e9),
+	"host":         "10.0.0.1",
+	"prev.memory":  float64(60),
+	"prev.net_out": float64(1e9),
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func TestEvalBool(t *testing.T) {
+	cases := []struct {
+		src  string
+		want bool
+	}{
+		// precedence
+		{"1 + 2 * 3 == 7", true},
+		{"(1 + 2) * 3 == 9", true},
+		{"10 - 4 - 3 == 3", true},
+		{"7 % 4 == 3", true},
+		{"-2 * -3 == 6", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!false && false", false},
+		{"not (memory > 80) or load > 30", true},
+		{"memory > 80 && load > 70", false},
+		{"memory > 80 and load > 30", true},
+
+		// in / not in
+		{"exit_code in (2, 3)", true},
+		{"exit_code in (1 + 2, 4)", false},
+		{"exit_code not in (2, 3)", false},
+		{"not exit_code in (2, 3)", false},
+		{"host in ('10.0.0.1', \"10.0.0.2\")", true},
+
+		// prev
+		{"memory - prev.memory > 20", true},
+		{"net_out - prev.net_out > 1e9", true},
+
+		// strings
+		{"host == '10.0.0.1'", true},
+		{"host < '10.0.0.2'", true},
+		{"host != \"10.0.0.1\"", false},
+	}
+
+	for _, c := range cases {
+		e, err := Compile(c.src, testTypeOf)
+		if err != nil {
+			t.Errorf("Compile(%q) err: %v", c.src, err)
+			continue
+		}
+		got, err := e.EvalBool(testEnv)
+		if err != nil {
+			t.Errorf("EvalBool(%q) err: %v", c.src, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("EvalBool(%q) = %v, want %v", c.src, got, c.want)
+		}
+	}
+}
+
+func TestVars(t *testing.T) {
+	e, err := Compile("memory > prev.memory && memory > 80", testTypeOf)
+	if err != nil {
+		t.Fatalf("Compile err: %v", err)
+	}
+	if got := strings.Join(e.Vars(), ","); got != "memory,prev.memory" {
+		t.Errorf("Vars() = %s, want memory,prev.memory", got)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	cases := []struct {
+		src string
+		err string
+	}{
+		// lexer
+		{"host == 'abc", "unterminated string"},
+		{"memory > 80 # 1", "unexpected character"},
+
+		// parser
+		{"", "unexpected end of expression"},
+		{"memory >", "unexpected end of expression"},
+		{"(memory > 80", `expect ")"`},
+		{"memory > 80 load", "unexpected"},
+		{"exit_code in 2, 3", `expect "("`},
+		{"disk > 80", "unknown field"},
+		{"prev.disk > 80", "unknown field"},
+
+		// types
+		{"memory + 1", "not bool"},
+		{"memory", "not bool"},
+		{"'a'", "not bool"},
+		{"memory > 'a'", "can not compare"},
+		{"host == 1", "can not compare"},
+		{"true > false", "can not compare"},
+		{"host + 1 > 2", "needs numbers"},
+		{"memory && true", "needs bool"},
+		{"!memory", "needs bool"},
+		{"-host == 1", "needs number"},
+		{"exit_code in (2, 'a')", "can not compare"},
+	}
+
+	for _, c := range cases {
+		_, err := Compile(c.src, testTypeOf)
+		if err == nil {
+			t.Errorf("Compile(%q) succeeded, want err containing %q", c.src, c.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Compile(%q) err: %v, want err containing %q", c.src, err, c.err)
+		}
+	}
+}
+
+func TestEvalError(t *testing.T) {
+	cases := []struct {
+		src string
+		env mapEnv
+		err string
+	}{
+		{"memory / 0 > 1", testEnv, "division by zero"},
+		{"memory % 0 > 1", testEnv, "division by zero"},
+		{"prev.exit_code in (2, 3)", testEnv, "not available"},
+		{"memory > 80", mapEnv{"memory": "85"}, "can not compare"},
+		{"memory + 1 > 80", mapEnv{"memory": true}, "needs numbers"},
+	}
+
+	for _, c := range cases {
+		e, err := Compile(c.src, testTypeOf)
+		if err != nil {
+			t.Errorf("Compile(%q) err: %v", c.src, err)
+			continue
+		}
+		_, err = e.EvalBool(c.env)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("EvalBool(%q) err: %v, want err containing %q", c.src, err, c.err)
+		}
+	}
+}
+
+func TestUntyped(t *testing.T) {
+	e, err := Compile("x", nil)
+	if err != nil {
+		t.Fatalf("Compile err: %v", err)
+	}
+	if _, err = e.EvalBool(mapEnv{"x": float64(1)}); err != ErrNotBool {
+		t.Errorf("EvalBool err: %v, want %v", err, ErrNotBool)
+	}
+
+	if _, err = Compile("x + 1 > 'a'", nil); err == nil {
+		t.Errorf("Compile succeeded, want number and string comparison err")
+	}
+}
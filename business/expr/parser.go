@@ -0,0 +1,293 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 语法（优先级由低到高）：
+//
+//	or      = and { ("||" | "or") and }
+//	and     = not { ("&&" | "and") not }
+//	not     = ("!" | "not") not | compare
+//	compare = sum [ ("==" | "!=" | ">" | ">=" | "<" | "<=") sum | ["not"] "in" "(" sum { "," sum } ")" ]
+//	sum     = product { ("+" | "-") product }
+//	product = unary { ("*" | "/" | "%") unary }
+//	unary   = "-" unary | primary
+//	primary = number | string | "true" | "false" | ident | "(" or ")"
+type parser struct {
+	tokens []token
+	pos    int
+	vars   map[string]bool
+	typeOf TypeOf
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *parser) parse() (node, error) {
+	n, err := this.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := this.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+	}
+
+	return n, nil
+}
+
+func (this *parser) parseOr() (node, error) {
+	x, err := this.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for this.accept("||", "or") {
+		y, err := this.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &binary{op: "||", x: x, y: y}
+	}
+
+	return x, nil
+}
+
+func (this *parser) parseAnd() (node, error) {
+	x, err := this.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for this.accept("&&", "and") {
+		y, err := this.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = &binary{op: "&&", x: x, y: y}
+	}
+
+	return x, nil
+}
+
+func (this *parser) parseNot() (node, error) {
+	// "not in" belongs to compare, only a leading "not" is negation
+	if this.accept("!") || (this.isWord("not") && !this.isWordAt(this.pos+1, "in") && this.accept("not")) {
+		x, err := this.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "!", x: x}, nil
+	}
+
+	return this.parseCompare()
+}
+
+func (this *parser) parseCompare() (node, error) {
+	x, err := this.parseSum()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := this.peek()
+	switch {
+	case tok.kind == tokenOperator && isCompareOperator(tok.text):
+		this.pos++
+		y, err := this.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return &binary{op: tok.text, x: x, y: y}, nil
+
+	case this.isWord("in") || (this.isWord("not") && this.isWordAt(this.pos+1, "in")):
+		isNot := this.accept("not")
+		this.pos++
+		list, err := this.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &inList{x: x, list: list, not: isNot}, nil
+	}
+
+	return x, nil
+}
+
+func (this *parser) parseList() ([]node, error) {
+	if err := this.expect("("); err != nil {
+		return nil, err
+	}
+
+	list := make([]node, 0, 4)
+	for {
+		n, err := this.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+
+		if !this.accept(",") {
+			break
+		}
+	}
+
+	if err := this.expect(")"); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (this *parser) parseSum() (node, error) {
+	x, err := this.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := this.peek()
+		if !this.accept("+", "-") {
+			return x, nil
+		}
+		y, err := this.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		x = &binary{op: tok.text, x: x, y: y}
+	}
+}
+
+func (this *parser) parseProduct() (node, error) {
+	x, err := this.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := this.peek()
+		if !this.accept("*", "/", "%") {
+			return x, nil
+		}
+		y, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &binary{op: tok.text, x: x, y: y}
+	}
+}
+
+func (this *parser) parseUnary() (node, error) {
+	if this.accept("-") {
+		x, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "-", x: x}, nil
+	}
+
+	return this.parsePrimary()
+}
+
+func (this *parser) parsePrimary() (node, error) {
+	tok := this.next()
+
+	switch tok.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", tok.text, tok.pos)
+		}
+		return &literal{value: value}, nil
+
+	case tokenString:
+		return &literal{value: tok.text}, nil
+
+	case tokenIdent:
+		switch strings.ToLower(tok.text) {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		}
+		if this.typeOf != nil {
+			if _, ok := this.typeOf(tok.text); !ok {
+				return nil, fmt.Errorf("unknown field %q at %d", tok.text, tok.pos)
+			}
+		}
+		this.vars[tok.text] = true
+		return &variable{name: tok.text}, nil
+
+	case tokenOperator:
+		if tok.text == "(" {
+			n, err := this.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err = this.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		}
+	}
+
+	if tok.kind == tokenEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *parser) peek() token {
+	return this.tokens[this.pos]
+}
+
+func (this *parser) next() token {
+	tok := this.tokens[this.pos]
+	if tok.kind != tokenEOF {
+		this.pos++
+	}
+	return tok
+}
+
+// 当前词法单元为指定的操作符或关键字时前进一步
+func (this *parser) accept(texts ...string) bool {
+	tok := this.peek()
+	for _, text := range texts {
+		if (tok.kind == tokenOperator && tok.text == text) ||
+			(tok.kind == tokenIdent && strings.EqualFold(tok.text, text)) {
+			this.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (this *parser) expect(text string) error {
+	if !this.accept(text) {
+		tok := this.peek()
+		return fmt.Errorf("expect %q but got %q at %d", text, tok.text, tok.pos)
+	}
+	return nil
+}
+
+func (this *parser) isWord(word string) bool {
+	return this.isWordAt(this.pos, word)
+}
+
+func (this *parser) isWordAt(pos int, word string) bool {
+	if pos >= len(this.tokens) {
+		return false
+	}
+	tok := this.tokens[pos]
+	return tok.kind == tokenIdent && strings.EqualFold(tok.text, word)
+}
+
+func isCompareOperator(op string) bool {
+	switch op {
+	case "==", "!=", ">", ">=", "<", "<=":
+		return true
+	}
+	return false
+}
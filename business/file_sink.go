@@ -0,0 +1,49 @@
+package business
+
+import (
+	"os"
+	"sync"
+)
+
+// JSONL 文件投递目标：每条报警 JSON 追加写入一行
+type fileSink struct {
+	path string     // 文件路径
+	file *os.File   // 追加打开的文件
+	l    sync.Mutex // 保证一条报警写入完整的一行
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newFileSink(path string) (*fileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileSink{
+		path: path,
+		file: file,
+	}, nil
+}
+
+func (this *fileSink) Name() string {
+	return "file:" + this.path
+}
+
+func (this *fileSink) Send(obj *alarmRequest, value []byte, done sinkCallback) {
+	line := make([]byte, 0, len(value)+1)
+	line = append(append(line, value...), '\n')
+
+	this.l.Lock()
+	_, err := this.file.Write(line)
+	this.l.Unlock()
+
+	done(this.path, -1, -1, err)
+}
+
+func (this *fileSink) Close() error {
+	this.l.Lock()
+	defer this.l.Unlock()
+
+	return this.file.Close()
+}
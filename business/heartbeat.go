@@ -0,0 +1,161 @@
+package business
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"state_monitor/config"
+	"state_monitor/model"
+	"state_monitor/model/redis"
+
+	"github.com/cihub/seelog"
+)
+
+// 记录服务的最后一次心跳，心跳恢复时发送恢复消息
+func (this *Pipeline) keepHeartbeat(stateObj *ReceiverStateMsg, m map[string]string) {
+	if stateObj == nil || stateObj.ServiceName == "" {
+		return
+	}
+
+	// service exit or heartbeat detection disabled, stop tracking heartbeat
+	interval, _ := strconv.ParseInt(m["heart_interval"], 10, 64)
+	if stateObj.ExitCode >= model.REPORT_STATE_COM_EXIT_CODE_EXIT_OK || interval <= 0 {
+		if err := this.heartbeatModel.Delete(stateObj.JobID, stateObj.ServiceName); err != nil {
+			seelog.Errorf("delete heartbeat record err: %v", err)
+		}
+		this.resolveAlarm(stateObj, model.ALARM_RULE_HEARTBEAT)
+		return
+	}
+
+	missNum, _ := strconv.ParseInt(m["heart_miss_num"], 10, 64)
+	if missNum <= 0 {
+		missNum = int64(config.GetConfig().Service.HeartMissNum)
+	}
+
+	heartTime := stateObj.HeartTime
+	if heartTime <= 0 {
+		heartTime = time.Now().Unix()
+	}
+
+	err := this.heartbeatModel.Set(&model.HeartbeatRecord{
+		JobID:       stateObj.JobID,
+		ServiceName: stateObj.ServiceName,
+		EnvType:     stateObj.EnvType,
+		Host:        stateObj.Host,
+		HeartTime:   heartTime,
+		Interval:    interval,
+		MissNum:     missNum,
+	})
+	if err != nil {
+		seelog.Errorf("set heartbeat record err: %v", err)
+		return
+	}
+
+	this.resolveAlarm(stateObj, model.ALARM_RULE_HEARTBEAT)
+}
+
+// 定期扫描心跳记录，对超时未上报心跳的服务发送报警
+func (this *Pipeline) sweepHeartbeat() {
+	defer this.wg.Done()
+
+	interval := time.Duration(config.GetConfig().Service.HeartSweepInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.chanExit:
+			return
+		case <-ticker.C:
+			// 多个消费者共享同一份心跳记录，每个扫描周期只允许一个消费者执行
+			locked, err := redis.TryLock(model.RDS_REPORT_STATE_HEARTBEAT, int(interval/time.Millisecond))
+			if err != nil {
+				seelog.Errorf("lock heartbeat sweep err: %v", err)
+				continue
+			}
+			if locked {
+				this.checkHeartbeat()
+			}
+		}
+	}
+}
+
+func (this *Pipeline) checkHeartbeat() {
+	records, err := this.heartbeatModel.GetAll()
+	if err != nil {
+		seelog.Errorf("get heartbeat records err: %v", err)
+		return
+	}
+
+	now := time.Now().Unix()
+	for _, record := range records {
+		if record.Deadline() >= now {
+			continue
+		}
+
+		// 重新读取记录，忽略扫描期间刚上报心跳的服务
+		latest, err := this.heartbeatModel.Get(record.JobID, record.ServiceName)
+		if err != nil || latest == nil || latest.HeartTime != record.HeartTime {
+			continue
+		}
+
+		m, err := this.monitorPolicyModel.GetFieldsMap(latest.JobID, latest.ServiceName, latest.EnvType)
+		if err != nil {
+			seelog.Errorf("get state monitor policy fields err: %v", err)
+			continue
+		}
+
+		// 策略已关闭心跳检测：不再跟踪该服务，恢复心跳报警
+		if interval, _ := strconv.ParseInt(m["heart_interval"], 10, 64); interval <= 0 {
+			if err = this.heartbeatModel.Delete(latest.JobID, latest.ServiceName); err != nil {
+				seelog.Errorf("delete heartbeat record err: %v", err)
+				continue
+			}
+			this.resolveRule(&alarmRequest{
+				JobID:       latest.JobID,
+				ServiceName: latest.ServiceName,
+				EnvType:     latest.EnvType,
+				Host:        latest.Host,
+				Rule:        model.ALARM_RULE_HEARTBEAT,
+			})
+			continue
+		}
+
+		// 已在报警中的服务只需检查升级，已升级或未开启升级时跳过
+		state, err := this.alarmStateModel.Get(latest.JobID, latest.ServiceName, model.ALARM_RULE_HEARTBEAT)
+		if err != nil {
+			seelog.Errorf("get alarm state err: %v", err)
+			continue
+		}
+		if state != nil && (state.Escalated || escalateAfter(m) <= 0) {
+			continue
+		}
+
+		obj := &alarmRequest{
+			JobID:       latest.JobID,
+			ServiceName: latest.ServiceName,
+			EnvType:     latest.EnvType,
+			Host:        latest.Host,
+			HeartTime:   now,
+			Rule:        model.ALARM_RULE_HEARTBEAT,
+			Status:      model.ALARM_STATUS_FIRING,
+			Severity:    severityOf(m, model.ALARM_RULE_HEARTBEAT, latest.EnvType),
+			Findings: []*alarmFinding{{
+				Rule:      model.ALARM_RULE_HEARTBEAT,
+				Message:   fmt.Sprintf("heartbeat lost, host: %s", latest.Host),
+				Value:     strconv.FormatInt(latest.HeartTime, 10),
+				Threshold: strconv.FormatInt(latest.Interval*latest.MissNum, 10),
+			}},
+			Content: fmt.Sprintf("JobID: %d, ServiceName: %s, Msg: %s",
+				latest.JobID, latest.ServiceName,
+				fmt.Sprintf("heartbeat lost, host: %s, last heart time: %d", latest.Host, latest.HeartTime)),
+			policy: m,
+		}
+		if this.fireAlarm(obj) {
+			this.sendAlarm(obj)
+		} else {
+			this.escalateAlarm(obj, m)
+		}
+	}
+}
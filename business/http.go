@@ -0,0 +1,92 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"state_monitor/business/ingest"
+	"state_monitor/model"
+
+	"github.com/cihub/seelog"
+)
+
+// http 消息来源：通过 POST /v1/state 接收单个或批量上报状态，请求体支持 gzip 压缩，
+// 配置了共享密钥时校验 X-State-Token 请求头。上报状态提交到流水线后即返回 accepted，
+// 服务在处理完成前退出时不会重新处理，需要至少处理一次的上报应使用 kafka 或 redis 来源
+type Http struct {
+	addr     string         // 监听地址
+	pipeline *Pipeline      // 上报状态处理流水线
+	server   *http.Server   // http 服务
+	wg       sync.WaitGroup // 等待服务协程退出
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewHttp(addr, token string, pipeline *Pipeline) *Http {
+	h := &Http{
+		addr:     addr,
+		pipeline: pipeline,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/v1/state", ingest.NewHandler(token, model.HTTP_MAX_BODY_SIZE, model.HTTP_MAX_BATCH_SIZE, h.submit))
+	h.server = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	return h
+}
+
+func (this *Http) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", this.addr)
+	if err != nil {
+		return err
+	}
+
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+		if err := this.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			seelog.Errorf("http source serve err: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// 停止接收请求，等待处理中的请求返回，ctx 结束时关闭剩余连接
+func (this *Http) Stop(ctx context.Context) error {
+	err := this.server.Shutdown(ctx)
+	if err == ctx.Err() {
+		this.server.Close()
+	}
+	this.wg.Wait()
+
+	return err
+}
+
+// 请求返回时消息已提交到流水线，没有需要等待的消息
+func (this *Http) Close(ctx context.Context) error {
+	return nil
+}
+
+func (this *Http) Pending() int {
+	return 0
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 校验并提交一个上报状态到流水线，不等待处理完成
+func (this *Http) submit(item json.RawMessage) error {
+	if _, err := decodeState(item); err != nil {
+		return err
+	}
+
+	return this.pipeline.Submit(newTicket("http", item, nil))
+}
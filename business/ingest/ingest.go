@@ -0,0 +1,148 @@
+// 上报状态的 http 接收：校验共享密钥，读取单个或批量上报状态（请求体支持 gzip 压缩），逐个提交给调用方
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/cihub/seelog"
+)
+
+const (
+	TokenHeader = "X-State-Token" // 共享密钥请求头
+
+	StatusAccepted = "accepted" // 已提交到处理队列，尚未处理和写入 MySQL
+	StatusRejected = "rejected" // 无法解析或提交失败，调用方需要重新上报
+)
+
+// 上报状态接收处理器：accepted 只表示上报状态已提交到处理队列，服务在处理完成前退出时该上报状态会丢失，
+// 不会像 kafka 等来源一样重新消费；需要至少处理一次的上报应使用 kafka 或 redis 来源
+type Handler struct {
+	token    string                      // 共享密钥，为空时不校验
+	maxBody  int64                       // 请求体最大字节数（解压后）
+	maxBatch int                         // 单次请求最多上报的状态数
+	submit   func(json.RawMessage) error // 提交一个上报状态，返回错误时该上报状态被拒绝
+}
+
+type Result struct {
+	Index  int    `json:"index"`           // 在请求中的序号
+	Status string `json:"status"`          // accepted.已提交到处理队列、rejected.已拒绝
+	Error  string `json:"error,omitempty"` // 拒绝原因
+}
+
+type Response struct {
+	Accepted int       `json:"accepted"`          // 提交到处理队列的状态数
+	Rejected int       `json:"rejected"`          // 拒绝的状态数
+	Results  []*Result `json:"results,omitempty"` // 每个状态的处理结果
+	Error    string    `json:"error,omitempty"`   // 请求错误
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewHandler(token string, maxBody int64, maxBatch int, submit func(json.RawMessage) error) *Handler {
+	return &Handler{
+		token:    token,
+		maxBody:  maxBody,
+		maxBatch: maxBatch,
+		submit:   submit,
+	}
+}
+
+// 处理上报请求：至少一个上报状态被接收时返回 200，否则返回 4xx，响应体中包含每个上报状态的处理结果
+func (this *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		this.reply(w, http.StatusMethodNotAllowed, &Response{Error: "method not allowed"})
+		return
+	}
+
+	if this.token != "" {
+		token := r.Header.Get(TokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(this.token)) != 1 {
+			this.reply(w, http.StatusUnauthorized, &Response{Error: "invalid token"})
+			return
+		}
+	}
+
+	items, err := this.readItems(w, r)
+	if err != nil {
+		this.reply(w, http.StatusBadRequest, &Response{Error: err.Error()})
+		return
+	}
+
+	resp := &Response{
+		Results: make([]*Result, 0, len(items)),
+	}
+	for i, item := range items {
+		result := &Result{Index: i, Status: StatusAccepted}
+		if err = this.submit(item); err != nil {
+			result.Status, result.Error = StatusRejected, err.Error()
+			resp.Rejected++
+		} else {
+			resp.Accepted++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	code := http.StatusOK
+	if resp.Accepted == 0 {
+		code = http.StatusBadRequest
+	}
+	this.reply(w, code, resp)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 读取请求体中的上报状态，请求体为单个 JSON 对象或 JSON 数组
+func (this *Handler) readItems(w http.ResponseWriter, r *http.Request) ([]json.RawMessage, error) {
+	var reader io.Reader = http.MaxBytesReader(w, r.Body, this.maxBody)
+	if strings.Contains(strings.ToLower(r.Header.Get("Content-Encoding")), "gzip") {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %v", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(reader, this.maxBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > this.maxBody {
+		return nil, fmt.Errorf("body is larger than %d bytes", this.maxBody)
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, errors.New("body is empty")
+	}
+	if body[0] != '[' {
+		return []json.RawMessage{body}, nil
+	}
+
+	var items []json.RawMessage
+	if err = json.Unmarshal(body, &items); err != nil {
+		return nil, fmt.Errorf("json unmarshal failed: %v", err)
+	}
+	if len(items) > this.maxBatch {
+		return nil, fmt.Errorf("batch is larger than %d items", this.maxBatch)
+	}
+
+	return items, nil
+}
+
+func (this *Handler) reply(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		seelog.Errorf("http source write response err: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 记录提交的上报状态，service_name 为 reject 的上报状态被拒绝
+type submitter struct {
+	items []string
+}
+
+func (this *submitter) submit(item json.RawMessage) error {
+	if strings.Contains(string(item), `"reject"`) {
+		return errors.New("rejected by pipeline")
+	}
+	this.items = append(this.items, string(item))
+	return nil
+}
+
+func post(t *testing.T, h http.Handler, header http.Header, body []byte) (int, *Response) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/state", bytes.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var resp Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, &resp
+}
+
+func TestSingle(t *testing.T) {
+	s := &submitter{}
+	code, resp := post(t, NewHandler("", 1024, 10, s.submit), nil, []byte(` {"service_name":"order_api"} `))
+	if code != http.StatusOK || resp.Accepted != 1 || resp.Rejected != 0 {
+		t.Fatalf("code = %d, resp = %+v, want 200 with 1 accepted", code, resp)
+	}
+	if len(s.items) != 1 || s.items[0] != `{"service_name":"order_api"}` {
+		t.Errorf("submitted = %q", s.items)
+	}
+}
+
+func TestBatchPartiallyRejected(t *testing.T) {
+	s := &submitter{}
+	body := []byte(`[{"service_name":"a"},{"service_name":"reject"},{"service_name":"b"}]`)
+	code, resp := post(t, NewHandler("", 1024, 10, s.submit), nil, body)
+	if code != http.StatusOK || resp.Accepted != 2 || resp.Rejected != 1 {
+		t.Fatalf("code = %d, resp = %+v, want 200 with 2 accepted and 1 rejected", code, resp)
+	}
+	if len(resp.Results) != 3 {
+		t.Fatalf("results = %d, want 3", len(resp.Results))
+	}
+	if r := resp.Results[1]; r.Index != 1 || r.Status != StatusRejected || r.Error == "" {
+		t.Errorf("results[1] = %+v, want rejected with error", r)
+	}
+	if r := resp.Results[2]; r.Index != 2 || r.Status != StatusAccepted {
+		t.Errorf("results[2] = %+v, want accepted", r)
+	}
+}
+
+func TestAllRejected(t *testing.T) {
+	s := &submitter{}
+	code, resp := post(t, NewHandler("", 1024, 10, s.submit), nil, []byte(`{"service_name":"reject"}`))
+	if code != http.StatusBadRequest || resp.Rejected != 1 {
+		t.Errorf("code = %d, resp = %+v, want 400 with 1 rejected", code, resp)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`[{"service_name":"a"},{"service_name":"b"}]`))
+	gz.Close()
+
+	s := &submitter{}
+	header := http.Header{"Content-Encoding": []string{"gzip"}}
+	code, resp := post(t, NewHandler("", 1024, 10, s.submit), header, buf.Bytes())
+	if code != http.StatusOK || resp.Accepted != 2 {
+		t.Fatalf("code = %d, resp = %+v, want 200 with 2 accepted", code, resp)
+	}
+
+	code, resp = post(t, NewHandler("", 1024, 10, s.submit), header, []byte(`{"service_name":"a"}`))
+	if code != http.StatusBadRequest || !strings.Contains(resp.Error, "gzip") {
+		t.Errorf("code = %d, resp = %+v, want 400 for an invalid gzip body", code, resp)
+	}
+}
+
+func TestToken(t *testing.T) {
+	s := &submitter{}
+	h := NewHandler("s3cret", 1024, 10, s.submit)
+	body := []byte(`{"service_name":"a"}`)
+
+	for _, token := range []string{"", "wrong"} {
+		code, _ := post(t, h, http.Header{TokenHeader: []string{token}}, body)
+		if code != http.StatusUnauthorized {
+			t.Errorf("token %q: code = %d, want 401", token, code)
+		}
+	}
+	if len(s.items) != 0 {
+		t.Fatalf("submitted %d items with an invalid token", len(s.items))
+	}
+
+	if code, _ := post(t, h, http.Header{TokenHeader: []string{"s3cret"}}, body); code != http.StatusOK {
+		t.Errorf("valid token: code = %d, want 200", code)
+	}
+}
+
+func TestOversize(t *testing.T) {
+	s := &submitter{}
+	h := NewHandler("", 32, 2, s.submit)
+
+	code, resp := post(t, h, nil, []byte(`{"service_name":"`+strings.Repeat("a", 64)+`"}`))
+	if code != http.StatusBadRequest || resp.Error == "" {
+		t.Errorf("body: code = %d, resp = %+v, want 400 for an oversize body", code, resp)
+	}
+
+	// 解压后超过限制的请求体同样被拒绝
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`[` + strings.Repeat(`{},`, 64) + `{}]`))
+	gz.Close()
+	code, resp = post(t, h, http.Header{"Content-Encoding": []string{"gzip"}}, buf.Bytes())
+	if code != http.StatusBadRequest || !strings.Contains(resp.Error, "larger than 32 bytes") {
+		t.Errorf("gzip body: code = %d, resp = %+v, want 400 for an oversize body", code, resp)
+	}
+
+	code, resp = post(t, h, nil, []byte(`[{},{},{}]`))
+	if code != http.StatusBadRequest || !strings.Contains(resp.Error, "larger than 2 items") {
+		t.Errorf("batch: code = %d, resp = %+v, want 400 for an oversize batch", code, resp)
+	}
+	if len(s.items) != 0 {
+		t.Errorf("submitted %d items from oversize requests", len(s.items))
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	s := &submitter{}
+	h := NewHandler("", 1024, 10, s.submit)
+
+	for _, body := range []string{"", "   ", `[{"service_name":"a"},`} {
+		code, resp := post(t, h, nil, []byte(body))
+		if code != http.StatusBadRequest || resp.Error == "" {
+			t.Errorf("body %q: code = %d, resp = %+v, want 400 with error", body, code, resp)
+		}
+	}
+	if len(s.items) != 0 {
+		t.Errorf("submitted %d items from malformed requests", len(s.items))
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	s := &submitter{}
+	rec := httptest.NewRecorder()
+	NewHandler("", 1024, 10, s.submit).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/state", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("code = %d, want 405", rec.Code)
+	}
+}
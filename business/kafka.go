@@ -1,365 +1,219 @@
 package business
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
-	"strconv"
-	"strings"
+	"context"
 	"sync"
 	"time"
 
 	"state_monitor/config"
 	"state_monitor/model"
 
-	"github.com/Shopify/sarama"
 	"github.com/bsm/sarama-cluster"
 	"github.com/cihub/seelog"
 )
 
+// kafka 消息来源：按分区消费上报主题，消息处理完成后按分区提交 offset。
+// 消费组 rebalance 时，消费者在 rebalance_timeout 后提交 offset 并释放被收回的分区，
+// 被收回分区已接收的消息在此之前（留出 KAFKA_REVOKE_MARGIN）处理完成才会提交
 type Kafka struct {
-	l                  sync.Mutex                   // 锁
-	consumer           *cluster.Consumer            // 消费者
-	producer           sarama.AsyncProducer         // 生产者
-	produceTopic       string                       // 生产者的主题
-	chanConsumerMsg    chan *sarama.ConsumerMessage // 消费消息通道
-	chanProducerValue  chan string                  // 生产消息的内容通道
-	chanExit           chan struct{}                // 携程退出消息通道
-	reportStateModel   *model.ReportState           // 上报状态模型
-	monitorPolicyModel *model.StateMonitorPolicy    // 监控策略模型
-}
-
-type cache struct {
-	l                 sync.Mutex    // 锁
-	firstMsgTimestamp int64         // 第一条消息存入的时间戳
-	values            []interface{} // 批量操作的对象值
-}
-
-var (
-	msgCache *cache // 消费的消息不直接写MySQL，而是写入缓存，然后批量写入MySQL
-)
-
-func init() {
-	msgCache = &cache{
-		values: make([]interface{}, 0, model.BATCH_INSERT_CAPS),
-	}
+	consumer      *cluster.Consumer  // 消费者
+	offsets       *offsetTracker     // 消费 offset 跟踪器
+	pipeline      *Pipeline          // 上报状态处理流水线
+	revokeTimeout time.Duration      // 分区被收回时等待已接收消息完成的最长时间，短于提交前的等待时间
+	l             sync.RWMutex       // 锁
+	isStopped     bool               // 是否已停止接收消息
+	partitions    map[string][]int32 // 当前分配到的分区
+	chanExit      chan struct{}      // 携程退出消息通道
+	wg            sync.WaitGroup     // 等待分区消费协程退出
+	receiverWg    sync.WaitGroup     // 等待分区分配和 rebalance 通知接收协程退出
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
 
-func NewKafka(brokers, consumerTopics []string, producerTopic string) (*Kafka, error) {
+func NewKafka(cfg *config.Kafka, pipeline *Pipeline) (*Kafka, error) {
+	rebalanceTimeout := time.Duration(cfg.RebalanceTimeout) * time.Second
 
-	// create kafka produce
-	produceConfig := sarama.NewConfig()
-	produceConfig.Producer.RequiredAcks = sarama.WaitForAll
-	produceConfig.Producer.Partitioner = sarama.NewRandomPartitioner
-	produceConfig.Producer.Return.Successes = true
-	produceConfig.Producer.Return.Errors = true
-	produceConfig.Version = sarama.V0_11_0_2
-	producer, err := sarama.NewAsyncProducer(brokers, produceConfig)
-	if err != nil {
+	// create kafka consumer, every partition is consumed by its own goroutine, the partition consumer is
+	// closed when the partition is revoked and its offsets are committed after the dwell time, the revoked
+	// partition stops marking offsets a margin before the commit
+	consumerConfig := cluster.NewConfig()
+	if err := applyKafkaConfig(&consumerConfig.Config, cfg); err != nil {
 		return nil, err
 	}
-
-	// create kafka consumer
-	groupId := "state_monitor_center"
-	consumerConfig := cluster.NewConfig()
 	consumerConfig.Consumer.Return.Errors = true
-	consumerConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
-	consumer, err := cluster.NewConsumer(brokers, groupId, consumerTopics, consumerConfig)
+	consumerConfig.Group.Mode = cluster.ConsumerModePartitions
+	consumerConfig.Group.Return.Notifications = true
+	consumerConfig.Group.Offsets.Synchronization.DwellTime = rebalanceTimeout
+	consumer, err := cluster.NewConsumer(cfg.Brokers, cfg.GroupID, cfg.ReceiveStateTopics, consumerConfig)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Kafka{
-		consumer:           consumer,
-		producer:           producer,
-		produceTopic:       producerTopic,
-		chanExit:           make(chan struct{}),
-		chanConsumerMsg:    make(chan *sarama.ConsumerMessage, model.CHAN_CONSUMER_MSG_CAPS),
-		chanProducerValue:  make(chan string, model.CHAN_CONSUMER_MSG_CAPS),
-		reportStateModel:   model.NewReportState(),
-		monitorPolicyModel: model.NewStateMonitorPolicy(),
+		consumer:      consumer,
+		offsets:       newOffsetTracker(consumer),
+		pipeline:      pipeline,
+		revokeTimeout: rebalanceTimeout - model.KAFKA_REVOKE_MARGIN*time.Millisecond,
+		partitions:    make(map[string][]int32),
+		chanExit:      make(chan struct{}),
 	}, nil
 }
 
-func (this *Kafka) Start() error {
+func (this *Kafka) Start(ctx context.Context) error {
 
-	// receiver msg from kafka
+	// receive partitions and rebalance notifications from kafka
+	this.receiverWg.Add(1)
 	go this.receiver()
 
-	// consumer msg
-	for i := 0; i < int(config.GetConfig().Service.JobPoolSize); i++ {
-		go this.consumerMsg()
-	}
-
-	// alarm from state_monitor_center to alarm_monitor_center
-	go this.alarm()
-
 	return nil
 }
 
-func (this *Kafka) Stop() error {
+// 停止接收消息，分区分配和 rebalance 通知在消费者关闭前继续接收
+func (this *Kafka) Stop(ctx context.Context) error {
 	this.l.Lock()
-	defer this.l.Unlock()
+	this.isStopped = true
+	this.l.Unlock()
 
 	close(this.chanExit)
-	close(this.chanConsumerMsg)
-	close(this.chanProducerValue)
+	this.wg.Wait()
 
-	if err := this.consumer.Close(); err != nil {
-		seelog.Errorf("close kafka consumer err: %v", err)
-	}
+	return nil
+}
 
-	if err := this.producer.Close(); err != nil {
-		seelog.Errorf("close kafka consumer err: %v", err)
+// 等待已接收的消息完成后关闭消费者，关闭时提交已完成消息的 offset，未完成的消息下次启动时重新消费
+func (this *Kafka) Close(ctx context.Context) error {
+	if pending := waitPending(ctx, this.Pending); pending > 0 {
+		seelog.Warnf("close kafka consumer with %d msgs unfinished, they will be consumed again", pending)
 	}
 
-	// 将消息缓存清空输出至 MySQL
-	if err := this.flushMsgCache(); err != nil {
-		seelog.Errorf("flush msg cache to mysql error: %v", err)
-	}
+	err := this.consumer.Close()
+	this.receiverWg.Wait()
 
-	return nil
+	return err
 }
 
-// ---------------------------------------------------------------------------------------------------------------------
+func (this *Kafka) Pending() int {
+	return this.offsets.pending()
+}
 
-func (this *Kafka) receiver() {
-	var err error
-	var msg *sarama.ConsumerMessage
+// 当前分配到的分区
+func (this *Kafka) Partitions() map[string][]int32 {
+	this.l.RLock()
+	defer this.l.RUnlock()
 
-	for {
-		select {
-		case <-this.chanExit:
-			return
-		case err = <-this.consumer.Errors():
-			if err != nil {
-				seelog.Errorf("consumer receiver err: %v", err)
-			}
-		case msg = <-this.consumer.Messages():
-			this.chanConsumerMsg <- msg
-			this.consumer.MarkOffset(msg, "")
-		}
+	partitions := make(map[string][]int32, len(this.partitions))
+	for topic, v := range this.partitions {
+		partitions[topic] = append([]int32(nil), v...)
 	}
+
+	return partitions
 }
 
-func (this *Kafka) consumerMsg() {
-	var err error
-	var content string
-	var stateObj ReceiverStateMsg
-	var msg *sarama.ConsumerMessage
-	var isNeed, isNotClosed bool
+// ---------------------------------------------------------------------------------------------------------------------
 
-	for {
+// 接收分配到的分区、rebalance 通知和错误，直到消费者关闭，消费者在分区和通知被接收前会阻塞
+func (this *Kafka) receiver() {
+	defer this.receiverWg.Done()
+
+	partitions := this.consumer.Partitions()
+	notifications := this.consumer.Notifications()
+	errors := this.consumer.Errors()
+
+	for partitions != nil || notifications != nil || errors != nil {
 		select {
-		case msg, isNotClosed = <-this.chanConsumerMsg:
-			if !isNotClosed {
-				return
-			}
-			if err = json.Unmarshal(msg.Value, &stateObj); err != nil {
-				seelog.Errorf("json unmarshal failed. [T:%s P:%d O:%d M:%s], err: %v",
-					msg.Topic, msg.Partition, msg.Offset, string(msg.Value), err)
+		case pc, ok := <-partitions:
+			if !ok {
+				partitions = nil
 				continue
 			}
-			if content, isNeed = this.isNeedAlarm(&stateObj); isNeed {
-				obj := alarmRequest{
-					JobID:       stateObj.JobID,
-					ServiceName: stateObj.ServiceName,
-					HeartTime:   time.Now().Unix(),
-					Content: fmt.Sprintf("JobID: %d, ServiceName: %s, Msg: %s",
-						stateObj.JobID, stateObj.ServiceName, content),
-				}
-				alarmPkg, _ := json.Marshal(obj)
-				this.chanProducerValue <- string(alarmPkg)
+			this.claim(pc)
+		case n, ok := <-notifications:
+			if !ok {
+				notifications = nil
+				continue
 			}
-
-			// 存储消息
-			stateObj.IsAlarm = isNeed
-			if err = this.store(&stateObj); err != nil {
-				seelog.Errorf("insert state err: %v", err)
+			this.rebalance(n)
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
 				continue
 			}
-
-			seelog.Infof("consumer report_state msg ok [T:%s P:%d O:%d M:%s]",
-				msg.Topic, msg.Partition, msg.Offset, string(msg.Value))
+			seelog.Errorf("consumer receiver err: %v", err)
 		}
 	}
 }
 
-func (this *Kafka) store(stateObj *ReceiverStateMsg) error {
-	if stateObj == nil || stateObj.ServiceName == "" {
-		return errors.New("params error, stateObj is null or serviceName is empty")
-	}
-
-	columns := []string{
-		"job_id", "service_name", "`status`", "env_type", "start_time", "stop_time", "heart_time", "exit_code",
-		"`host`", "process_id", "memory", "`load`", "net_in", "net_out", "extend", "is_alarm", "create_time",
-	}
-	value := []interface{}{
-		stateObj.JobID, stateObj.ServiceName, stateObj.Status, stateObj.EnvType,
-		stateObj.StartTime, stateObj.StopTime, stateObj.HeartTime, stateObj.ExitCode,
-		stateObj.Host, stateObj.ProcessID, stateObj.Memory, stateObj.Load,
-		stateObj.NetIn, stateObj.NetOut, stateObj.Extend, stateObj.IsAlarm, time.Now().Unix(),
-	}
-
-	msgCache.l.Lock()
-	defer msgCache.l.Unlock()
-
-	length := len(msgCache.values)
-	diffTime := time.Now().Unix() - msgCache.firstMsgTimestamp - model.BATCH_INSERT_INTERVAL_TIME
-
-	seelog.Warnf("diffTime: %d, firstMsgTimestamp: %d", diffTime, msgCache.firstMsgTimestamp)
-
-	if length >= model.BATCH_INSERT_CAPS {
-		seelog.Warnf("0000")
-		_, err := this.reportStateModel.RollingBatchInsert(columns, msgCache.values)
-		if err != nil {
-			return err
-		}
-
-		// 复位 msgCache
-		this.resetMsgCache()
-
-		// 将消息写入缓存并记录时间
-		msgCache.values = append(msgCache.values, value)
-		if msgCache.firstMsgTimestamp == 0 {
-			msgCache.firstMsgTimestamp = time.Now().Unix()
-		}
-	} else if diffTime > 0 && msgCache.firstMsgTimestamp > 0 {
-		seelog.Warnf("1111")
-		msgCache.values = append(msgCache.values, value)
-		id, err := this.reportStateModel.RollingBatchInsert(columns, msgCache.values)
-		if err != nil {
-			return err
-		}
-
-		seelog.Warnf("insert lastid: %d", id)
+// 开始消费分配到的分区，停止接收消息后分配到的分区不再消费
+func (this *Kafka) claim(pc cluster.PartitionConsumer) {
+	this.l.Lock()
+	defer this.l.Unlock()
 
-		// 复位 msgCache
-		this.resetMsgCache()
-	} else {
-		seelog.Warnf("2222")
-		msgCache.values = append(msgCache.values, value)
-		if msgCache.firstMsgTimestamp == 0 {
-			msgCache.firstMsgTimestamp = time.Now().Unix()
-		}
+	if this.isStopped {
+		return
 	}
 
-	return nil
+	this.wg.Add(1)
+	go this.consume(pc, this.offsets.claim(pc.Topic(), pc.Partition()))
 }
 
-// 将缓存中的数据写入到 MySQL（该kafka退出前执行）
-func (this *Kafka) flushMsgCache() error {
-	msgCache.l.Lock()
-	defer msgCache.l.Unlock()
+// 消费分区直到分区被收回或停止接收消息；流水线已停止时丢弃之后的消息，但继续读取直到分区被收回，避免阻塞消费者
+func (this *Kafka) consume(pc cluster.PartitionConsumer, p *partitionOffsets) {
+	defer this.wg.Done()
 
-	if len(msgCache.values) > 0 {
-		columns := []string{
-			"job_id", "service_name", "status", "env_type", "start_time", "stop_time", "heart_time",
-			"exit_code", "host", "process_id", "memory", "load", "net_in", "net_out", "extend", "create_time",
-		}
-		_, err := this.reportStateModel.RollingBatchInsert(columns, msgCache.values)
-		if err != nil {
-			return err
+	submitting := true
+	for {
+		select {
+		case <-this.chanExit:
+			return
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				this.revoke(p)
+				return
+			}
+			if !submitting {
+				continue
+			}
+			// offset 在消息写入 MySQL 且报警投递确认后才提交
+			if err := this.pipeline.Submit(this.offsets.track(p, msg)); err != nil {
+				seelog.Errorf("submit kafka msg [T:%s P:%d O:%d] err: %v, discard msgs until the partition is revoked",
+					msg.Topic, msg.Partition, msg.Offset, err)
+				this.offsets.untrack(p, msg)
+				submitting = false
+			}
 		}
-
-		// 复位 msgCache
-		this.resetMsgCache()
 	}
-
-	return nil
 }
 
-// 复位消息缓存
-func (this *Kafka) resetMsgCache() {
-	msgCache.firstMsgTimestamp = 0
-	msgCache.values = msgCache.values[0:0]
-}
+// 分区被收回：立即写入缓存的上报状态，在 revokeTimeout 内等待该分区已接收的消息完成，
+// 完成的 offset 由消费者在释放分区前提交，未完成的消息由分配到该分区的消费者重新消费
+func (this *Kafka) revoke(p *partitionOffsets) {
+	this.pipeline.Flush()
 
-func (this *Kafka) isNeedAlarm(stateObj *ReceiverStateMsg) (string, bool) {
-	if stateObj == nil || stateObj.ServiceName == "" {
-		return "", false
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), this.revokeTimeout)
+	defer cancel()
+	waitPending(ctx, func() int {
+		return this.offsets.pendingOf(p)
+	})
 
-	m, err := this.monitorPolicyModel.GetFieldsMap(stateObj.JobID, stateObj.ServiceName)
-	if err != nil {
-		seelog.Errorf("get state monitor policy fields err: %v", err)
-		return "", false
+	if pending := this.offsets.release(p); pending > 0 {
+		seelog.Warnf("kafka partition [T:%s P:%d] revoked with %d msgs unfinished, they will be consumed again",
+			p.topic, p.partition, pending)
+		return
 	}
-
-	if v, ok := m["memory"]; ok {
-		memory, _ := strconv.Atoi(v)
-		if stateObj.Memory > memory {
-			return fmt.Sprintf("memory usage is too high, usage: %d", stateObj.Memory), true
-		}
-	}
-
-	if v, ok := m["status"]; ok {
-		status, _ := strconv.Atoi(v)
-		if stateObj.Status == model.REPORT_STATE_COM_STATUS_FAILED && stateObj.Status == status {
-			return "service status exception", true
-		}
-	}
-
-	if v, ok := m["exit_code"]; ok {
-		s := strings.Split(v, "#")
-		for _, value := range s {
-			exitCode, _ := strconv.Atoi(value)
-			if stateObj.ExitCode > model.REPORT_STATE_COM_EXIT_CODE_EXIT_OK && stateObj.ExitCode == exitCode {
-				return "service exit exception", true
-			}
-		}
-	}
-
-	// service exit, delete redis cache
-	if stateObj.ExitCode >= model.REPORT_STATE_COM_EXIT_CODE_EXIT_OK {
-		this.monitorPolicyModel.DeleteCache(stateObj.JobID, stateObj.ServiceName)
-	}
-
-	return "", false
+	seelog.Infof("kafka partition [T:%s P:%d] revoked", p.topic, p.partition)
 }
 
-func (this *Kafka) alarm() {
-
-	go func() {
-		var value []byte
-		var suc *sarama.ProducerMessage
-		var fail *sarama.ProducerError
-
-		for {
-			select {
-			case <-this.chanExit:
-				return
-			case suc = <-this.producer.Successes():
-				value, _ = suc.Value.Encode()
-				seelog.Infof("send alarm msg success. offset: %d, msgValue: %+v", suc.Offset, string(value))
-			case fail = <-this.producer.Errors():
-				seelog.Infof("send alarm msg failed: %s", fail.Err.Error())
-			}
-		}
-	}()
-
-	go func() {
-		var value string
-		var isNotClosed bool
-		msg := &sarama.ProducerMessage{
-			Topic: this.produceTopic,
-			Key:   sarama.StringEncoder("state_monitor_center"),
-		}
-
-		for {
-			select {
-			case value, isNotClosed = <-this.chanProducerValue:
-				if !isNotClosed {
-					return
-				}
-
-				msg.Value = sarama.ByteEncoder(value)
-
-				// send msg
-				this.producer.Input() <- msg
-			}
-		}
-	}()
+func (this *Kafka) rebalance(n *cluster.Notification) {
+	switch n.Type {
+	case cluster.RebalanceStart:
+		seelog.Infof("kafka consumer rebalance start, current partitions %v", n.Current)
+	case cluster.RebalanceOK:
+		this.l.Lock()
+		this.partitions = n.Current
+		this.l.Unlock()
+		seelog.Infof("kafka consumer rebalance ok, claimed %v, released %v, current partitions %v",
+			n.Claimed, n.Released, n.Current)
+	case cluster.RebalanceError:
+		seelog.Warnf("kafka consumer rebalance err, partitions before rebalance %v", n.Current)
+	}
 }
@@ -0,0 +1,128 @@
+package business
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"time"
+
+	"state_monitor/business/scram"
+	"state_monitor/config"
+
+	"github.com/Shopify/sarama"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 将配置文件中的 kafka 客户端配置应用到 sarama 配置，消费者、生产者和死信重放共用
+func applyKafkaConfig(c *sarama.Config, cfg *config.Kafka) error {
+	version, err := sarama.ParseKafkaVersion(cfg.Version)
+	if err != nil {
+		return err
+	}
+	c.Version = version
+
+	// consumer
+	if cfg.InitialOffset == "oldest" {
+		c.Consumer.Offsets.Initial = sarama.OffsetOldest
+	} else {
+		c.Consumer.Offsets.Initial = sarama.OffsetNewest
+	}
+	if cfg.FetchMin > 0 {
+		c.Consumer.Fetch.Min = cfg.FetchMin
+	}
+	if cfg.FetchDefault > 0 {
+		c.Consumer.Fetch.Default = cfg.FetchDefault
+	}
+	c.Consumer.Fetch.Max = cfg.FetchMax
+
+	// producer
+	switch cfg.RequiredAcks {
+	case "none":
+		c.Producer.RequiredAcks = sarama.NoResponse
+	case "local":
+		c.Producer.RequiredAcks = sarama.WaitForLocal
+	default:
+		c.Producer.RequiredAcks = sarama.WaitForAll
+	}
+	switch cfg.Compression {
+	case "gzip":
+		c.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		c.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		c.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		c.Producer.Compression = sarama.CompressionZSTD
+	default:
+		c.Producer.Compression = sarama.CompressionNone
+	}
+	switch cfg.Partitioner {
+	case "random":
+		c.Producer.Partitioner = sarama.NewRandomPartitioner
+	case "round_robin":
+		c.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	default:
+		c.Producer.Partitioner = sarama.NewHashPartitioner
+	}
+	c.Producer.Retry.Max = *cfg.RetryMax
+	c.Producer.Retry.Backoff = time.Duration(cfg.RetryBackoff) * time.Millisecond
+
+	// tls
+	if cfg.Tls.Enable {
+		tlsConfig, err := newKafkaTlsConfig(&cfg.Tls)
+		if err != nil {
+			return err
+		}
+		c.Net.TLS.Enable = true
+		c.Net.TLS.Config = tlsConfig
+	}
+
+	// sasl
+	if cfg.Sasl.Enable {
+		c.Net.SASL.Enable = true
+		c.Net.SASL.Handshake = true
+		c.Net.SASL.Mechanism = sarama.SASLMechanism(cfg.Sasl.Mechanism)
+		c.Net.SASL.User = cfg.Sasl.User
+		c.Net.SASL.Password = cfg.Sasl.Password
+		switch cfg.Sasl.Mechanism {
+		case sarama.SASLTypeSCRAMSHA256:
+			c.Net.SASL.Version = sarama.SASLHandshakeV1
+			c.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return scram.NewClient(scram.SHA256) }
+		case sarama.SASLTypeSCRAMSHA512:
+			c.Net.SASL.Version = sarama.SASLHandshakeV1
+			c.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return scram.NewClient(scram.SHA512) }
+		}
+	}
+
+	return c.Validate()
+}
+
+func newKafkaTlsConfig(cfg *config.KafkaTls) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CaFile != "" {
+		ca, err := ioutil.ReadFile(cfg.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificate found in kafka tls ca_file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,205 @@
+package business
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"state_monitor/config"
+	"state_monitor/model"
+
+	"github.com/Shopify/sarama"
+	"github.com/cihub/seelog"
+)
+
+// 投递结果回调，投递成功时 err 为 nil
+type producerCallback func(msg *sarama.ProducerMessage, err error)
+
+// 投递记录：作为消息的 Metadata，投递结果据此对应到报警或死信
+type producerRecord struct {
+	id   uint64           // 投递序号
+	desc string           // 投递内容描述，用于日志
+	done producerCallback // 最终投递结果回调
+}
+
+// 最终投递结果，由回调协程处理
+type producerResult struct {
+	msg *sarama.ProducerMessage // 消息
+	err error                   // 投递失败的原因
+}
+
+// kafka 异步生产者：报警和死信共用，失败由 sarama 按 retry_max 重试，每个 broker 只有一个未完成的请求，
+// 重试不会打乱同一分区（同一服务）的消息顺序；回调可能写数据库，在单独的协程中执行，不阻塞投递结果的读取；
+// 关闭时等待所有投递得到最终结果并完成回调
+type kafkaProducer struct {
+	producer sarama.AsyncProducer // 生产者
+	seq      uint64               // 投递序号
+	pending  int64                // 未得到最终结果的投递数
+	l        sync.RWMutex         // 锁
+	isClosed bool                 // 是否已停止接收投递
+	chanDone chan *producerResult // 待回调的投递结果
+	inflight sync.WaitGroup       // 等待未完成回调的投递
+	wg       sync.WaitGroup       // 等待投递结果处理协程和回调协程退出
+}
+
+// 投递报警到 kafka 主题
+type kafkaSink struct {
+	producer *kafkaProducer // 共用的生产者
+	topic    string         // 生产者的主题
+}
+
+var errProducerClosed = errors.New("kafka producer is closed")
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newKafkaProducer(cfg *config.Kafka) (*kafkaProducer, error) {
+	produceConfig := sarama.NewConfig()
+	if err := applyKafkaConfig(produceConfig, cfg); err != nil {
+		return nil, err
+	}
+	produceConfig.Producer.Return.Successes = true
+	produceConfig.Producer.Return.Errors = true
+	produceConfig.Net.MaxOpenRequests = 1
+	// without retries the producer never duplicates msgs, sarama also rejects an idempotent producer without retries
+	if produceConfig.Producer.Retry.Max > 0 {
+		if produceConfig.Producer.RequiredAcks == sarama.WaitForAll {
+			produceConfig.Producer.Idempotent = true
+		} else {
+			seelog.Warnf("kafka producer is not idempotent without required_acks all, retries may duplicate msgs")
+		}
+	}
+	producer, err := sarama.NewAsyncProducer(cfg.Brokers, produceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &kafkaProducer{
+		producer: producer,
+		chanDone: make(chan *producerResult, model.CHAN_PRODUCER_RESULT_CAPS),
+	}
+	p.wg.Add(2)
+	go p.results()
+	go p.callbacks()
+
+	return p, nil
+}
+
+// 异步投递一条消息，最终投递结果回调 done，生产者关闭后直接回调 errProducerClosed
+func (this *kafkaProducer) send(msg *sarama.ProducerMessage, desc string, done producerCallback) {
+	this.l.RLock()
+	if this.isClosed {
+		this.l.RUnlock()
+		done(msg, errProducerClosed)
+		return
+	}
+	this.inflight.Add(1)
+	atomic.AddInt64(&this.pending, 1)
+	this.l.RUnlock()
+
+	msg.Metadata = &producerRecord{
+		id:   atomic.AddUint64(&this.seq, 1),
+		desc: desc,
+		done: done,
+	}
+	this.producer.Input() <- msg
+}
+
+// 停止接收投递，等待已投递消息得到最终结果并完成回调后关闭生产者
+func (this *kafkaProducer) close() {
+	this.l.Lock()
+	this.isClosed = true
+	this.l.Unlock()
+
+	this.inflight.Wait()
+	this.producer.AsyncClose()
+	this.wg.Wait()
+}
+
+// 未得到最终结果的投递数
+func (this *kafkaProducer) backlog() int {
+	return int(atomic.LoadInt64(&this.pending))
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *kafkaProducer) results() {
+	defer this.wg.Done()
+	defer close(this.chanDone)
+
+	successes, errors := this.producer.Successes(), this.producer.Errors()
+	for successes != nil || errors != nil {
+		select {
+		case suc, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			record := suc.Metadata.(*producerRecord)
+			seelog.Infof("send msg success. [#%d %s T:%s P:%d O:%d]",
+				record.id, record.desc, suc.Topic, suc.Partition, suc.Offset)
+			this.chanDone <- &producerResult{msg: suc}
+		case fail, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			record := fail.Msg.Metadata.(*producerRecord)
+			seelog.Errorf("send msg failed. [#%d %s T:%s] err: %v", record.id, record.desc, fail.Msg.Topic, fail.Err)
+			this.chanDone <- &producerResult{msg: fail.Msg, err: fail.Err}
+		}
+	}
+}
+
+func (this *kafkaProducer) callbacks() {
+	defer this.wg.Done()
+
+	for result := range this.chanDone {
+		result.msg.Metadata.(*producerRecord).done(result.msg, result.err)
+		atomic.AddInt64(&this.pending, -1)
+		this.inflight.Done()
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newKafkaSink(producer *kafkaProducer, topic string) *kafkaSink {
+	return &kafkaSink{
+		producer: producer,
+		topic:    topic,
+	}
+}
+
+func (this *kafkaSink) Name() string {
+	return "kafka:" + this.topic
+}
+
+// 以 JobID#ServiceName 为 key，同一服务的报警进入同一分区，保证下游按顺序消费
+func (this *kafkaSink) Send(obj *alarmRequest, value []byte, done sinkCallback) {
+	msg := &sarama.ProducerMessage{
+		Topic: this.topic,
+		Key:   sarama.StringEncoder(fmt.Sprintf("%d#%s", obj.JobID, obj.ServiceName)),
+		Value: sarama.ByteEncoder(value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(model.ALARM_HEADER_RULE), Value: []byte(obj.Rule)},
+			{Key: []byte(model.ALARM_HEADER_STATUS), Value: []byte(obj.Status)},
+			{Key: []byte(model.ALARM_HEADER_SEVERITY), Value: []byte(obj.Severity)},
+			{Key: []byte(model.ALARM_HEADER_ENV_TYPE), Value: []byte(strconv.Itoa(obj.EnvType))},
+			{Key: []byte(model.ALARM_HEADER_SCHEMA_VERSION), Value: []byte(model.ALARM_SCHEMA_VERSION)},
+		},
+	}
+	desc := fmt.Sprintf("alarm %d#%s %s %s", obj.JobID, obj.ServiceName, obj.Rule, obj.Status)
+	this.producer.send(msg, desc, func(msg *sarama.ProducerMessage, err error) {
+		if err != nil {
+			done(this.topic, -1, -1, err)
+			return
+		}
+		done(this.topic, msg.Partition, msg.Offset, nil)
+	})
+}
+
+// 生产者由 Pipeline 统一关闭
+func (this *kafkaSink) Close() error {
+	return nil
+}
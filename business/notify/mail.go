@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 邮件：通过 smtp 发送，服务器支持时使用 STARTTLS，配置了用户时使用 PLAIN 认证，认证失败不需要重试
+type Mail struct {
+	addr     string        // smtp 地址
+	host     string        // smtp 主机
+	user     string        // 用户
+	password string        // 密码
+	from     string        // 发件人
+	to       []string      // 收件人
+	timeout  time.Duration // 单次发送超时时间
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewMail(host string, port int, user, password, from string, to []string, timeout time.Duration) *Mail {
+	return &Mail{
+		addr:     net.JoinHostPort(host, strconv.Itoa(port)),
+		host:     host,
+		user:     user,
+		password: password,
+		from:     from,
+		to:       to,
+		timeout:  timeout,
+	}
+}
+
+func (this *Mail) Addr() string {
+	return this.addr
+}
+
+// 与 smtp.SendMail 相同的流程，增加连接和读写超时
+func (this *Mail) Send(subject, body string) error {
+	conn, err := net.DialTimeout("tcp", this.addr, this.timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(this.timeout))
+
+	client, err := smtp.NewClient(conn, this.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: this.host}); err != nil {
+			return err
+		}
+	}
+	if this.user != "" {
+		if err = client.Auth(smtp.PlainAuth("", this.user, this.password, this.host)); err != nil {
+			return Permanent(err)
+		}
+	}
+	if err = client.Mail(this.from); err != nil {
+		return err
+	}
+	for _, to := range this.to {
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(this.message(subject, body)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (this *Mail) message(subject, body string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", this.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(this.to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", encodeSubject(subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(&buf, "\r\n")
+	fmt.Fprintf(&buf, "%s\r\n", body)
+
+	return buf.Bytes()
+}
+
+// 主题包含上报中的服务名称，去掉换行避免注入邮件头，非 ASCII 字符按 RFC 2047 编码
+func encodeSubject(subject string) string {
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)
+	return mime.QEncoding.Encode("utf-8", subject)
+}
@@ -0,0 +1,261 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/base64"
+	"mime"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 本地 smtp 替身：支持 EHLO、AUTH PLAIN、MAIL、RCPT、DATA、QUIT，记录收到的邮件
+type smtpStandIn struct {
+	ln     net.Listener
+	auth   bool // 是否支持 AUTH PLAIN
+	authOK bool // 认证是否成功
+	silent bool // 不发送欢迎信息，用于测试超时
+
+	l        sync.Mutex
+	from     string
+	rcpt     []string
+	data     string
+	identity string
+}
+
+// 开始监听，s 中的选项在监听前设置
+func startSMTPStandIn(t *testing.T, s *smtpStandIn) *smtpStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err: %v", err)
+	}
+	s.ln = ln
+	go s.serve()
+
+	return s
+}
+
+func (this *smtpStandIn) port() int {
+	return this.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (this *smtpStandIn) close() {
+	this.ln.Close()
+}
+
+func (this *smtpStandIn) serve() {
+	for {
+		conn, err := this.ln.Accept()
+		if err != nil {
+			return
+		}
+		go this.handle(conn)
+	}
+}
+
+func (this *smtpStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+
+	if this.silent {
+		time.Sleep(time.Second)
+		return
+	}
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP stand-in")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		this.l.Lock()
+		switch cmd {
+		case "EHLO":
+			reply("250-localhost")
+			if this.auth {
+				reply("250-AUTH PLAIN")
+			}
+			reply("250 8BITMIME")
+		case "AUTH":
+			fields := strings.Fields(line)
+			identity, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			this.identity = string(identity)
+			if this.authOK {
+				reply("235 2.7.0 authentication succeeded")
+			} else {
+				reply("535 5.7.8 authentication credentials invalid")
+			}
+		case "MAIL":
+			// 去掉 BODY=8BITMIME 等参数
+			this.from = strings.Fields(line[len("MAIL FROM:"):])[0]
+			reply("250 ok")
+		case "RCPT":
+			this.rcpt = append(this.rcpt, line[len("RCPT TO:"):])
+			reply("250 ok")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					this.l.Unlock()
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			this.data = data.String()
+			reply("250 ok queued")
+		case "QUIT":
+			reply("221 bye")
+			this.l.Unlock()
+			return
+		default:
+			reply("502 command not implemented")
+		}
+		this.l.Unlock()
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func TestMailSend(t *testing.T) {
+	s := startSMTPStandIn(t, &smtpStandIn{})
+	defer s.close()
+
+	to := []string{"ops@example.com", "dev@example.com"}
+	mail := NewMail("127.0.0.1", s.port(), "", "", "monitor@example.com", to, time.Second)
+	if mail.Addr() != "127.0.0.1:"+strconv.Itoa(s.port()) {
+		t.Errorf("Addr = %s", mail.Addr())
+	}
+	if err := mail.Send("[critical][firing] order_api memory", "memory 95 > 80"); err != nil {
+		t.Fatalf("Send err: %v", err)
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.from != "<monitor@example.com>" {
+		t.Errorf("from = %s", s.from)
+	}
+	if strings.Join(s.rcpt, ",") != "<ops@example.com>,<dev@example.com>" {
+		t.Errorf("rcpt = %v", s.rcpt)
+	}
+	for _, want := range []string{
+		"From: monitor@example.com\r\n",
+		"To: ops@example.com, dev@example.com\r\n",
+		"Subject: [critical][firing] order_api memory\r\n",
+		"Content-Type: text/plain; charset=UTF-8\r\n",
+		"\r\n\r\nmemory 95 > 80\r\n",
+	} {
+		if !strings.Contains(s.data, want) {
+			t.Errorf("data does not contain %q:\n%s", want, s.data)
+		}
+	}
+	if s.identity != "" {
+		t.Errorf("auth sent without user: %q", s.identity)
+	}
+}
+
+func TestMailAuth(t *testing.T) {
+	s := startSMTPStandIn(t, &smtpStandIn{auth: true, authOK: true})
+	defer s.close()
+
+	mail := NewMail("127.0.0.1", s.port(), "monitor", "pass", "monitor@example.com", []string{"ops@example.com"},
+		time.Second)
+	if err := mail.Send("subject", "body"); err != nil {
+		t.Fatalf("Send err: %v", err)
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.identity != "\x00monitor\x00pass" {
+		t.Errorf("identity = %q", s.identity)
+	}
+	if !strings.Contains(s.data, "body") {
+		t.Errorf("data = %q", s.data)
+	}
+}
+
+func TestMailAuthFailed(t *testing.T) {
+	s := startSMTPStandIn(t, &smtpStandIn{auth: true})
+	defer s.close()
+
+	mail := NewMail("127.0.0.1", s.port(), "monitor", "wrong", "monitor@example.com", []string{"ops@example.com"},
+		time.Second)
+	attempts := 0
+	err := Retry(3, time.Millisecond, func() error {
+		attempts++
+		return mail.Send("subject", "body")
+	}, nil)
+	if !IsPermanent(err) {
+		t.Errorf("err = %v, want permanent auth err", err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+// 服务名称来自上报，包含换行时不能注入邮件头
+func TestMailSubjectEncoded(t *testing.T) {
+	s := startSMTPStandIn(t, &smtpStandIn{})
+	defer s.close()
+
+	mail := NewMail("127.0.0.1", s.port(), "", "", "monitor@example.com", []string{"ops@example.com"}, time.Second)
+	subject := "[critical][firing] order\r\nBcc: evil@example.com\r\n\r\ninjected 订单 memory"
+	if err := mail.Send(subject, "body"); err != nil {
+		t.Fatalf("Send err: %v", err)
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	header := s.data[:strings.Index(s.data, "\r\n\r\n")]
+	if strings.Contains(header, "\r\nBcc:") {
+		t.Errorf("header injected:\n%s", header)
+	}
+	var got string
+	for _, line := range strings.Split(header, "\r\n") {
+		if strings.HasPrefix(line, "Subject: ") {
+			got = line[len("Subject: "):]
+		}
+	}
+	decoded, err := new(mime.WordDecoder).DecodeHeader(got)
+	if err != nil {
+		t.Fatalf("decode subject %q err: %v", got, err)
+	}
+	if want := "[critical][firing] order  Bcc: evil@example.com    injected 订单 memory"; decoded != want {
+		t.Errorf("subject = %q, want %q", decoded, want)
+	}
+	if !strings.HasSuffix(s.data, "\r\n\r\nbody\r\n") {
+		t.Errorf("data = %q", s.data)
+	}
+}
+
+func TestMailTimeout(t *testing.T) {
+	s := startSMTPStandIn(t, &smtpStandIn{silent: true})
+	defer s.close()
+
+	mail := NewMail("127.0.0.1", s.port(), "", "", "monitor@example.com", []string{"ops@example.com"},
+		100*time.Millisecond)
+	start := time.Now()
+	err := mail.Send("subject", "body")
+	if err == nil || IsPermanent(err) {
+		t.Errorf("err = %v, want retryable timeout err", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Send returned after %v, want about 100ms", elapsed)
+	}
+}
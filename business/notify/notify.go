@@ -0,0 +1,45 @@
+// 报警通知的发送方式：webhook、邮件，以及发送失败时的重试
+package notify
+
+import (
+	"time"
+)
+
+// 不需要重试的错误，例如认证失败、4xx 响应
+type permanentError struct {
+	error
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 将错误标记为不需要重试
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+func IsPermanent(err error) bool {
+	_, ok := err.(permanentError)
+	return ok
+}
+
+// 发送失败时按指数退避重试，最多重试 retries 次，不需要重试的错误立即返回；onRetry 在每次重试前回调，可以为 nil
+func Retry(retries int, backoff time.Duration, send func() error, onRetry func(n int, wait time.Duration, err error)) error {
+	var err error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			if onRetry != nil {
+				onRetry(i, backoff, err)
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = send(); err == nil || IsPermanent(err) {
+			return err
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-State-Monitor-Signature" // 签名：sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+	TimestampHeader = "X-State-Monitor-Timestamp" // 签名时间戳，接收方可据此拒绝重放请求
+)
+
+// webhook：POST JSON，网络错误、5xx 和 429 可以重试，其它错误不需要重试
+type Webhook struct {
+	url    string       // 地址
+	secret string       // 签名密钥，为空时不签名
+	client *http.Client // http 客户端
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewWebhook(url, secret string, timeout time.Duration) *Webhook {
+	return &Webhook{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (this *Webhook) Post(value []byte) error {
+	req, err := http.NewRequest(http.MethodPost, this.url, bytes.NewReader(value))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if this.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, "sha256="+Sign(this.secret, timestamp, value))
+	}
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("webhook response status %d", resp.StatusCode)
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+
+	return Permanent(err)
+}
+
+// 请求签名，接收方使用相同的密钥计算后与签名请求头比较
+func Sign(secret, timestamp string, value []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(value)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
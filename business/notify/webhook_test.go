@@ -0,0 +1,129 @@
+package notify
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"job_id":1,"service_name":"order_api","status":"firing"}`)
+	secret := "s3cret"
+
+	var signature, timestamp string
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(SignatureHeader)
+		timestamp = r.Header.Get(TimestampHeader)
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	if err := NewWebhook(server.URL, secret, time.Second).Post(body); err != nil {
+		t.Fatalf("Post err: %v", err)
+	}
+	if string(received) != string(body) {
+		t.Errorf("body = %s, want %s", received, body)
+	}
+	if timestamp == "" {
+		t.Fatalf("%s header is empty", TimestampHeader)
+	}
+	if want := "sha256=" + Sign(secret, timestamp, body); signature != want {
+		t.Errorf("signature = %s, want %s", signature, want)
+	}
+}
+
+// 签名为 hex(HMAC-SHA256(secret, timestamp + "." + body))，期望值由独立实现计算
+func TestSign(t *testing.T) {
+	want := "1698a50bc74d1ff1db85c4e0a5297c2ad9fdba245d5737cdb789e4cc6e098940"
+	if got := Sign("s3cret", "1700000000", []byte(`{"a":1}`)); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer server.Close()
+
+	if err := NewWebhook(server.URL, "", time.Second).Post([]byte("{}")); err != nil {
+		t.Fatalf("Post err: %v", err)
+	}
+	if signature != "" {
+		t.Errorf("signature = %s, want empty", signature)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	cases := []struct {
+		status    int
+		retries   int
+		requests  int32
+		permanent bool
+	}{
+		{http.StatusOK, 3, 1, false},
+		{http.StatusServiceUnavailable, 3, 4, false},
+		{http.StatusTooManyRequests, 2, 3, false},
+		{http.StatusBadRequest, 3, 1, true},
+		{http.StatusUnauthorized, 3, 1, true},
+	}
+
+	for _, c := range cases {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(c.status)
+		}))
+
+		webhook := NewWebhook(server.URL, "", time.Second)
+		retried := 0
+		err := Retry(c.retries, time.Millisecond, func() error {
+			return webhook.Post([]byte("{}"))
+		}, func(n int, wait time.Duration, err error) {
+			retried++
+		})
+		server.Close()
+
+		if got := atomic.LoadInt32(&requests); got != c.requests {
+			t.Errorf("status %d: requests = %d, want %d", c.status, got, c.requests)
+		}
+		if retried != int(c.requests)-1 {
+			t.Errorf("status %d: onRetry called %d times, want %d", c.status, retried, c.requests-1)
+		}
+		if c.status == http.StatusOK {
+			if err != nil {
+				t.Errorf("status %d: err = %v, want nil", c.status, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("status %d: err = nil", c.status)
+		}
+		if IsPermanent(err) != c.permanent {
+			t.Errorf("status %d: IsPermanent = %v, want %v", c.status, IsPermanent(err), c.permanent)
+		}
+	}
+}
+
+func TestWebhookNetworkErrorRetried(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	attempts := 0
+	err := Retry(2, time.Millisecond, func() error {
+		attempts++
+		return NewWebhook(url, "", time.Second).Post([]byte("{}"))
+	}, nil)
+	if err == nil || IsPermanent(err) {
+		t.Errorf("err = %v, want retryable network err", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
@@ -0,0 +1,135 @@
+package business
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/bsm/sarama-cluster"
+)
+
+// 一次分区分配的消费进度，分区被收回后重新分配到的是新的 partitionOffsets
+type partitionOffsets struct {
+	topic     string         // 主题
+	partition int32          // 分区
+	offsets   []int64        // 按消费顺序排列的未提交 offset
+	done      map[int64]bool // 已处理完成但未提交的 offset
+}
+
+// offset 跟踪器：按分区只提交连续处理完成的最大 offset，保证消息至少被处理一次
+type offsetTracker struct {
+	l          sync.Mutex
+	consumer   *cluster.Consumer
+	partitions map[string]map[int32]*partitionOffsets
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newOffsetTracker(consumer *cluster.Consumer) *offsetTracker {
+	return &offsetTracker{
+		consumer:   consumer,
+		partitions: make(map[string]map[int32]*partitionOffsets),
+	}
+}
+
+// 开始消费分配到的分区，替换该分区之前的消费进度
+func (this *offsetTracker) claim(topic string, partition int32) *partitionOffsets {
+	this.l.Lock()
+	defer this.l.Unlock()
+
+	partitions, ok := this.partitions[topic]
+	if !ok {
+		partitions = make(map[int32]*partitionOffsets)
+		this.partitions[topic] = partitions
+	}
+	p := &partitionOffsets{
+		topic:     topic,
+		partition: partition,
+		offsets:   make([]int64, 0, 64),
+		done:      make(map[int64]bool),
+	}
+	partitions[partition] = p
+
+	return p
+}
+
+// 分区被收回，丢弃其消费进度，之后完成的消息不再标记，由新的消费者重新消费；返回未完成的消息数
+func (this *offsetTracker) release(p *partitionOffsets) int {
+	this.l.Lock()
+	defer this.l.Unlock()
+
+	if this.partitions[p.topic][p.partition] == p {
+		delete(this.partitions[p.topic], p.partition)
+	}
+
+	return len(p.offsets)
+}
+
+// 登记消费到的消息，必须按消费顺序调用，凭证完成时标记 offset
+func (this *offsetTracker) track(p *partitionOffsets, msg *sarama.ConsumerMessage) *ticket {
+	this.l.Lock()
+	p.offsets = append(p.offsets, msg.Offset)
+	this.l.Unlock()
+
+	t := newTicket(msg.Topic, msg.Value, func() {
+		this.complete(p, msg)
+	})
+	t.partition = msg.Partition
+	t.offset = msg.Offset
+	t.key = msg.Key
+
+	return t
+}
+
+// 取消登记提交到流水线失败的消息，该消息及之后的消息由分配到该分区的消费者重新消费
+func (this *offsetTracker) untrack(p *partitionOffsets, msg *sarama.ConsumerMessage) {
+	this.l.Lock()
+	defer this.l.Unlock()
+
+	if n := len(p.offsets); n > 0 && p.offsets[n-1] == msg.Offset {
+		p.offsets = p.offsets[:n-1]
+	}
+}
+
+// 消息处理完成，提交该分区连续处理完成的最大 offset，分区已被收回时忽略
+func (this *offsetTracker) complete(p *partitionOffsets, msg *sarama.ConsumerMessage) {
+	this.l.Lock()
+	defer this.l.Unlock()
+
+	if this.partitions[p.topic][p.partition] != p {
+		return
+	}
+	p.done[msg.Offset] = true
+
+	marked := int64(-1)
+	for len(p.offsets) > 0 && p.done[p.offsets[0]] {
+		marked = p.offsets[0]
+		delete(p.done, marked)
+		p.offsets = p.offsets[1:]
+	}
+	if marked >= 0 {
+		this.consumer.MarkPartitionOffset(p.topic, p.partition, marked, "")
+	}
+}
+
+// 分区已登记但未标记的消息数
+func (this *offsetTracker) pendingOf(p *partitionOffsets) int {
+	this.l.Lock()
+	defer this.l.Unlock()
+
+	return len(p.offsets)
+}
+
+// 已登记但未标记的消息数
+func (this *offsetTracker) pending() int {
+	this.l.Lock()
+	defer this.l.Unlock()
+
+	count := 0
+	for _, partitions := range this.partitions {
+		for _, p := range partitions {
+			count += len(p.offsets)
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,626 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"state_monitor/config"
+	"state_monitor/model"
+
+	"github.com/cihub/seelog"
+)
+
+// 上报状态处理流水线：消息来源（Consumer）提交消息，流水线执行监控策略、写入 MySQL 并投递报警
+type Pipeline struct {
+	l                  sync.RWMutex               // 锁
+	isClosed           bool                       // 是否已停止接收消息
+	sinksClosed        bool                       // 报警投递目标是否已关闭
+	wg                 sync.WaitGroup             // 等待处理协程退出
+	producer           *kafkaProducer             // kafka 生产者，未配置 kafka 时为 nil
+	sinks              []*sinkRoute               // 报警投递目标及路由条件
+	escalateSink       AlarmSink                  // 升级报警额外投递的目标，可以为 nil
+	deadLetter         *deadLetterQueue           // 死信队列，可以为 nil
+	chanMsg            chan *ticket               // 待处理消息通道
+	chanExit           chan struct{}              // 携程退出消息通道
+	writer             *batchWriter               // 上报状态批量写入器
+	alarmWriter        *batchWriter               // 报警记录批量写入器
+	templates          *alarmTemplates            // 报警内容模板
+	monitorPolicyModel *model.StateMonitorPolicy  // 监控策略模型
+	heartbeatModel     *model.ReportHeartbeat     // 服务心跳模型
+	alarmStateModel    *model.AlarmState          // 报警状态模型
+	alarmDedupModel    *model.AlarmDedup          // 报警去重模型
+	previousModel      *model.ReportPrevious      // 上一次上报内容模型
+	silenceModel       *model.StateMonitorSilence // 报警静默模型
+	ownerModel         *model.StateMonitorOwner   // 服务负责团队模型
+}
+
+// 按模板渲染后的报警
+type renderedAlarm struct {
+	obj   *alarmRequest // 报警，内容与原报警不同时为副本
+	value []byte        // 报警 JSON
+	err   error         // JSON 序列化错误
+}
+
+// 流水线未完成的工作，停止超时时报告被放弃的工作
+type PipelineBacklog struct {
+	Msgs    int // 已提交但未处理的消息数
+	Rows    int // 未写入 MySQL 的上报状态数
+	Records int // 未写入 MySQL 的报警记录数
+	Alarms  int // 未得到投递结果的报警和死信数
+}
+
+// report_state_x 表的写入字段
+var reportStateColumns = []string{
+	"job_id", "service_name", "`status`", "env_type", "start_time", "stop_time", "heart_time", "exit_code",
+	"`host`", "process_id", "memory", "`load`", "net_in", "net_out", "extend", "is_alarm", "create_time",
+}
+
+var errPipelineClosed = errors.New("pipeline is closed")
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewPipeline() (*Pipeline, error) {
+	cfg := config.GetConfig()
+
+	p := &Pipeline{
+		sinks:              make([]*sinkRoute, 0, 2),
+		chanMsg:            make(chan *ticket, model.CHAN_CONSUMER_MSG_CAPS),
+		chanExit:           make(chan struct{}),
+		monitorPolicyModel: model.NewStateMonitorPolicy(),
+		heartbeatModel:     model.NewReportHeartbeat(),
+		alarmStateModel:    model.NewAlarmState(),
+		alarmDedupModel:    model.NewAlarmDedup(),
+		previousModel:      model.NewReportPrevious(),
+		silenceModel:       model.NewStateMonitorSilence(),
+		ownerModel:         model.NewStateMonitorOwner(),
+	}
+
+	// kafka producer is shared by kafka sinks and the dead letter queue
+	kafkaEnabled := cfg.Sinks.Kafka.Enable || cfg.Kafka.DeadLetterTopic != ""
+	for _, sink := range cfg.Sinks.KafkaTopics {
+		kafkaEnabled = kafkaEnabled || sink.Enable
+	}
+	if kafkaEnabled {
+		producer, err := newKafkaProducer(&cfg.Kafka)
+		if err != nil {
+			return nil, err
+		}
+		p.producer = producer
+	}
+
+	// alarm from state_monitor_center to alarm_monitor_center
+	if cfg.Sinks.Kafka.Enable {
+		if err := p.addSink(newKafkaSink(p.producer, cfg.Kafka.SendAlarmTopic), &cfg.Sinks.Kafka); err != nil {
+			return nil, err
+		}
+		if cfg.Kafka.EscalateAlarmTopic != "" {
+			p.escalateSink = newKafkaSink(p.producer, cfg.Kafka.EscalateAlarmTopic)
+		}
+	}
+	if cfg.Sinks.RedisStream.Enable {
+		sink := newRedisStreamSink(cfg.Sinks.RedisStream.Key, cfg.Sinks.RedisStream.MaxLen)
+		if err := p.addSink(sink, &cfg.Sinks.RedisStream); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.addListSinks(cfg.Sinks); err != nil {
+		return nil, err
+	}
+	if cfg.Kafka.DeadLetterTopic != "" {
+		p.deadLetter = newDeadLetterQueue(p.producer, cfg.Kafka.DeadLetterTopic)
+	}
+
+	templates, err := newAlarmTemplates()
+	if err != nil {
+		return nil, err
+	}
+	p.templates = templates
+
+	// write state and alarm records to mysql in batch
+	batchSize, queueSize := int(cfg.Service.BatchInsertCaps), int(cfg.Service.BatchQueueCaps)
+	writers, interval := int(cfg.Service.BatchWriterNum), time.Duration(cfg.Service.BatchInsertInterval)*time.Second
+	p.writer = newBatchWriter("state", reportStateColumns, func() rollingTable {
+		return model.NewReportState()
+	}, batchSize, queueSize, writers, interval)
+	p.alarmWriter = newBatchWriter("alarm record", reportAlarmColumns, func() rollingTable {
+		return model.NewReportAlarm()
+	}, batchSize, queueSize, writers, interval)
+
+	return p, nil
+}
+
+// 添加路由条件匹配时投递的报警目标
+func (this *Pipeline) addSink(sink AlarmSink, cfg *config.Sink) error {
+	route, err := newSinkRoute(sink, cfg)
+	if err != nil {
+		sink.Close()
+		return err
+	}
+	this.sinks = append(this.sinks, route)
+
+	return nil
+}
+
+// 添加可以配置多个的报警目标：kafka 主题、redis 发布、webhook、邮件和 JSONL 文件
+func (this *Pipeline) addListSinks(sinks config.Sinks) error {
+	for i := range sinks.KafkaTopics {
+		cfg := &sinks.KafkaTopics[i]
+		if !cfg.Enable {
+			continue
+		}
+		if err := this.addSink(newKafkaSink(this.producer, cfg.Topic), cfg); err != nil {
+			return err
+		}
+	}
+	for i := range sinks.RedisPublishs {
+		cfg := &sinks.RedisPublishs[i]
+		if !cfg.Enable {
+			continue
+		}
+		if err := this.addSink(newRedisPublishSink(cfg.Channel), cfg); err != nil {
+			return err
+		}
+	}
+	for i := range sinks.Webhooks {
+		cfg := &sinks.Webhooks[i]
+		if !cfg.Enable {
+			continue
+		}
+		sink := newWebhookSink(cfg.Url, cfg.Secret, int(*cfg.Retries), time.Duration(cfg.Timeout)*time.Second)
+		if err := this.addSink(sink, cfg); err != nil {
+			return err
+		}
+	}
+	for i := range sinks.Emails {
+		cfg := &sinks.Emails[i]
+		if !cfg.Enable {
+			continue
+		}
+		sink := newEmailSink(cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.From, cfg.To, int(*cfg.Retries),
+			time.Duration(cfg.Timeout)*time.Second)
+		if err := this.addSink(sink, cfg); err != nil {
+			return err
+		}
+	}
+	for i := range sinks.Files {
+		cfg := &sinks.Files[i]
+		if !cfg.Enable {
+			continue
+		}
+		sink, err := newFileSink(cfg.Path)
+		if err != nil {
+			return err
+		}
+		if err = this.addSink(sink, cfg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (this *Pipeline) Start(ctx context.Context) error {
+
+	// write state and alarm records to mysql until ctx is done
+	this.writer.start(ctx)
+	this.alarmWriter.start(ctx)
+
+	// consumer msg
+	for i := 0; i < int(config.GetConfig().Service.JobPoolSize); i++ {
+		this.wg.Add(1)
+		go this.consumerMsg()
+	}
+
+	// alarm services which stop reporting heartbeat
+	this.wg.Add(1)
+	go this.sweepHeartbeat()
+
+	// digest alarms suppressed within the window
+	this.wg.Add(1)
+	go this.sweepDigest()
+
+	return nil
+}
+
+// 停止流水线，需在所有消息来源停止后调用，依次执行 Drain、CloseSinks、CloseWriter
+func (this *Pipeline) Stop() error {
+	this.Drain()
+	this.CloseSinks()
+	this.CloseWriter()
+
+	return nil
+}
+
+// 停止接收消息，等待处理协程处理完已提交的消息、心跳扫描协程退出
+func (this *Pipeline) Drain() {
+	this.l.Lock()
+	if this.isClosed {
+		this.l.Unlock()
+		return
+	}
+	this.isClosed = true
+	close(this.chanMsg)
+	close(this.chanExit)
+	this.l.Unlock()
+
+	this.wg.Wait()
+}
+
+// 关闭报警投递目标和 kafka 生产者，等待已发送报警和死信的投递结果，需在 Drain 之后调用
+func (this *Pipeline) CloseSinks() {
+	this.l.Lock()
+	if this.sinksClosed {
+		this.l.Unlock()
+		return
+	}
+	this.sinksClosed = true
+	this.l.Unlock()
+
+	sinks := make([]AlarmSink, 0, len(this.sinks)+1)
+	for _, route := range this.sinks {
+		sinks = append(sinks, route.sink)
+	}
+	if this.escalateSink != nil {
+		sinks = append(sinks, this.escalateSink)
+	}
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			seelog.Errorf("close alarm sink %s err: %v", sink.Name(), err)
+		}
+	}
+	if this.producer != nil {
+		this.producer.close()
+	}
+}
+
+// 写入缓存的上报状态和报警记录后停止批量写入器，需在 Drain 和 CloseSinks 之后调用
+func (this *Pipeline) CloseWriter() {
+	this.writer.stop()
+	this.alarmWriter.stop()
+}
+
+// 统计未完成的工作
+func (this *Pipeline) Backlog() PipelineBacklog {
+	stats, records := this.writer.stats(), this.alarmWriter.stats()
+	backlog := PipelineBacklog{
+		Msgs:    len(this.chanMsg),
+		Rows:    int(stats.Enqueued - stats.Flushed),
+		Records: int(records.Enqueued - records.Flushed),
+	}
+
+	if this.producer != nil {
+		backlog.Alarms += this.producer.backlog()
+	}
+	for _, route := range this.sinks {
+		if sink, ok := route.sink.(*asyncSink); ok {
+			backlog.Alarms += sink.backlog()
+		}
+	}
+
+	return backlog
+}
+
+// 提交一条消息，处理协程繁忙时阻塞
+func (this *Pipeline) Submit(t *ticket) error {
+	this.l.RLock()
+	defer this.l.RUnlock()
+
+	if this.isClosed {
+		return errPipelineClosed
+	}
+	this.chanMsg <- t
+
+	return nil
+}
+
+// 立即将缓存的上报状态和报警记录写入 MySQL，不等待写入间隔
+func (this *Pipeline) Flush() {
+	this.writer.flush()
+	this.alarmWriter.flush()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *Pipeline) consumerMsg() {
+	defer this.wg.Done()
+
+	var t *ticket
+	var isNotClosed bool
+
+	for {
+		select {
+		case t, isNotClosed = <-this.chanMsg:
+			if !isNotClosed {
+				return
+			}
+			this.process(t)
+		}
+	}
+}
+
+// 处理一条上报消息，无法解析或被拒绝的消息投递到死信主题
+func (this *Pipeline) process(t *ticket) {
+	defer t.done()
+
+	stateObj, err := decodeState(t.value)
+	if err != nil {
+		this.deadLetter.send(t, err)
+		return
+	}
+	stateObj.ticket = t
+
+	fields := this.getPolicyFields(stateObj)
+	prev := this.keepPrevious(stateObj)
+	this.keepHeartbeat(stateObj, fields)
+
+	// 每条命中的规则单独报警，未命中的规则置为已恢复
+	findings := this.evaluate(stateObj, prev, fields)
+	firing := make(map[string]bool, len(findings))
+	for _, finding := range findings {
+		firing[finding.Rule] = true
+		this.raiseAlarm(stateObj, fields, finding)
+	}
+	this.resolveAlarms(stateObj, firing)
+
+	// 存储消息
+	stateObj.IsAlarm = len(findings) > 0
+	if err = this.store(stateObj); err != nil {
+		seelog.Errorf("insert state err: %v", err)
+		return
+	}
+
+	seelog.Infof("consumer report_state msg ok [%s M:%s]", t, string(t.value))
+}
+
+// 将消息交给批量写入器，写入 MySQL 后完成消息处理凭证
+func (this *Pipeline) store(stateObj *ReceiverStateMsg) error {
+	if stateObj == nil || stateObj.ServiceName == "" {
+		return errors.New("params error, stateObj is null or serviceName is empty")
+	}
+
+	return this.writer.enqueue([]interface{}{
+		stateObj.JobID, stateObj.ServiceName, stateObj.Status, stateObj.EnvType,
+		stateObj.StartTime, stateObj.StopTime, stateObj.HeartTime, stateObj.ExitCode,
+		stateObj.Host, stateObj.ProcessID, stateObj.Memory, stateObj.Load,
+		stateObj.NetIn, stateObj.NetOut, stateObj.Extend, stateObj.IsAlarm, time.Now().Unix(),
+	}, stateObj.ticket)
+}
+
+// 获取服务的监控策略，获取失败时返回 nil
+func (this *Pipeline) getPolicyFields(stateObj *ReceiverStateMsg) map[string]string {
+	if stateObj == nil || stateObj.ServiceName == "" {
+		return nil
+	}
+
+	m, err := this.monitorPolicyModel.GetFieldsMap(stateObj.JobID, stateObj.ServiceName, stateObj.EnvType)
+	if err != nil {
+		seelog.Errorf("get state monitor policy fields err: %v", err)
+		return nil
+	}
+
+	return m
+}
+
+// 对上报消息执行策略中的所有规则，返回全部命中的规则
+func (this *Pipeline) evaluate(stateObj, prev *ReceiverStateMsg, m map[string]string) []*alarmFinding {
+	if stateObj == nil || stateObj.ServiceName == "" {
+		return nil
+	}
+
+	// service exit, delete redis cache
+	defer func() {
+		if stateObj.ExitCode >= model.REPORT_STATE_COM_EXIT_CODE_EXIT_OK {
+			this.monitorPolicyModel.DeleteCache(stateObj.JobID, stateObj.ServiceName)
+			rules.delete(stateObj.JobID, stateObj.ServiceName)
+		}
+	}()
+
+	findings := make([]*alarmFinding, 0)
+
+	if v, ok := m["memory"]; ok {
+		memory, _ := strconv.Atoi(v)
+		if stateObj.Memory > memory {
+			findings = append(findings, &alarmFinding{
+				Rule:      model.ALARM_RULE_MEMORY,
+				Message:   fmt.Sprintf("memory usage is too high, usage: %d", stateObj.Memory),
+				Value:     strconv.Itoa(stateObj.Memory),
+				Threshold: v,
+			})
+		}
+	}
+
+	if v, ok := m["load"]; ok {
+		load, _ := strconv.Atoi(v)
+		if stateObj.Load > load {
+			findings = append(findings, &alarmFinding{
+				Rule:      model.ALARM_RULE_LOAD,
+				Message:   fmt.Sprintf("load is too high, load: %d", stateObj.Load),
+				Value:     strconv.Itoa(stateObj.Load),
+				Threshold: v,
+			})
+		}
+	}
+
+	// net_in/net_out thresholds are bytes per second
+	if netIn, netOut, ok := netRate(stateObj, prev); ok {
+		if v, ok := m["net_in"]; ok {
+			threshold, _ := strconv.ParseFloat(v, 64)
+			if netIn > threshold {
+				findings = append(findings, &alarmFinding{
+					Rule:      model.ALARM_RULE_NET_IN,
+					Message:   fmt.Sprintf("net in rate is too high, rate: %.0f B/s", netIn),
+					Value:     strconv.FormatFloat(netIn, 'f', 0, 64),
+					Threshold: v,
+				})
+			}
+		}
+		if v, ok := m["net_out"]; ok {
+			threshold, _ := strconv.ParseFloat(v, 64)
+			if netOut > threshold {
+				findings = append(findings, &alarmFinding{
+					Rule:      model.ALARM_RULE_NET_OUT,
+					Message:   fmt.Sprintf("net out rate is too high, rate: %.0f B/s", netOut),
+					Value:     strconv.FormatFloat(netOut, 'f', 0, 64),
+					Threshold: v,
+				})
+			}
+		}
+	}
+
+	if v, ok := m["status"]; ok {
+		status, _ := strconv.Atoi(v)
+		if stateObj.Status == model.REPORT_STATE_COM_STATUS_FAILED && stateObj.Status == status {
+			findings = append(findings, &alarmFinding{
+				Rule:      model.ALARM_RULE_STATUS,
+				Message:   "service status exception",
+				Value:     strconv.Itoa(stateObj.Status),
+				Threshold: v,
+			})
+		}
+	}
+
+	if v, ok := m["exit_code"]; ok {
+		for _, value := range strings.Split(v, "#") {
+			exitCode, _ := strconv.Atoi(value)
+			if stateObj.ExitCode > model.REPORT_STATE_COM_EXIT_CODE_EXIT_OK && stateObj.ExitCode == exitCode {
+				findings = append(findings, &alarmFinding{
+					Rule:      model.ALARM_RULE_EXIT_CODE,
+					Message:   "service exit exception",
+					Value:     strconv.Itoa(stateObj.ExitCode),
+					Threshold: v,
+				})
+				break
+			}
+		}
+	}
+
+	for _, hit := range this.evalRules(stateObj, prev, m) {
+		findings = append(findings, &alarmFinding{
+			Rule:    hit.name,
+			Message: fmt.Sprintf("rule %s matched", hit.name),
+			Expr:    hit.expr.String(),
+		})
+	}
+
+	return findings
+}
+
+// 投递报警到所有目标，命中静默规则的报警不投递，只记录；升级的报警同时投递到升级报警目标。
+// 报警内容按投递目标的模板渲染，相同模板的目标共用一份渲染结果
+func (this *Pipeline) sendAlarm(obj *alarmRequest) {
+	templates := this.templates.load()
+	rendered := make(map[string]*renderedAlarm)
+	render := func(name string) *renderedAlarm {
+		if r, ok := rendered[name]; ok {
+			return r
+		}
+		r := &renderedAlarm{obj: obj}
+		if content := this.templates.render(templates, name, obj); content != obj.Content {
+			copied := *obj
+			copied.Content = content
+			r.obj = &copied
+		}
+		r.value, r.err = json.Marshal(r.obj)
+		rendered[name] = r
+		return r
+	}
+
+	this.resolveOwner(obj)
+	def := render("")
+	if silence := this.matchSilence(obj); silence != nil {
+		def.obj.SilenceID = silence.ID
+		seelog.Infof("alarm silenced by silence %d. [%d#%s rule: %s]",
+			silence.ID, obj.JobID, obj.ServiceName, obj.Rule)
+		this.recordAlarm(def.obj, model.REPORT_ALARM_SEND_STATUS_NONE, "", "", -1, -1)
+		return
+	}
+
+	matched := 0
+	for _, route := range this.sinks {
+		if !route.match(obj) {
+			continue
+		}
+		matched++
+		if r := render(route.template); r.err != nil {
+			seelog.Errorf("json marshal alarm err: %v", r.err)
+		} else {
+			this.deliver(route.sink, r.obj, r.value)
+		}
+	}
+	if matched == 0 {
+		seelog.Warnf("alarm matches no sink. [%d#%s rule: %s severity: %s]",
+			obj.JobID, obj.ServiceName, obj.Rule, obj.Severity)
+		this.recordAlarm(def.obj, model.REPORT_ALARM_SEND_STATUS_NONE, "no sink matched", "", -1, -1)
+	}
+	if obj.Escalated && this.escalateSink != nil && def.err == nil {
+		this.deliver(this.escalateSink, def.obj, def.value)
+	}
+}
+
+// 投递报警到指定目标，投递完成后记录投递结果并完成一次消息处理凭证
+func (this *Pipeline) deliver(sink AlarmSink, obj *alarmRequest, value []byte) {
+	obj.ticket.add(1)
+	sink.Send(obj, value, func(dest string, partition int32, offset int64, err error) {
+		if err != nil {
+			this.recordAlarm(obj, model.REPORT_ALARM_SEND_STATUS_FAILED, err.Error(), dest, -1, -1)
+		} else {
+			this.recordAlarm(obj, model.REPORT_ALARM_SEND_STATUS_SUCCESS, "", dest, partition, offset)
+		}
+		obj.ticket.done()
+	})
+}
+
+// 填充报警的负责团队、联系人和通知渠道
+func (this *Pipeline) resolveOwner(obj *alarmRequest) {
+	if obj.Team != "" {
+		return
+	}
+
+	owner, err := this.ownerModel.Get(obj.JobID, obj.ServiceName)
+	if err != nil {
+		seelog.Errorf("get service owner err: %v", err)
+		return
+	}
+	if owner != nil {
+		obj.Team = owner.Team
+		obj.Contacts = owner.Contacts
+		obj.Channels = owner.Channels
+	}
+}
+
+// 获取报警命中的静默规则，未命中时返回 nil
+func (this *Pipeline) matchSilence(obj *alarmRequest) *model.SilenceRecord {
+	silences, err := this.silenceModel.GetUnexpired()
+	if err != nil {
+		seelog.Errorf("get silences err: %v", err)
+		return nil
+	}
+
+	now := time.Now().Unix()
+	for _, silence := range silences {
+		if silence.Match(obj.JobID, obj.ServiceName, obj.Host, obj.EnvType, now) {
+			return silence
+		}
+	}
+
+	return nil
+}
+
+// 解析并校验上报消息
+func decodeState(value []byte) (*ReceiverStateMsg, error) {
+	stateObj := &ReceiverStateMsg{}
+	if err := json.Unmarshal(value, stateObj); err != nil {
+		return nil, fmt.Errorf("json unmarshal failed: %v", err)
+	}
+	if stateObj.ServiceName == "" {
+		return nil, errors.New("serviceName is empty")
+	}
+
+	return stateObj, nil
+}
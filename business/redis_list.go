@@ -0,0 +1,179 @@
+package business
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"state_monitor/model"
+	"state_monitor/model/redis"
+
+	"github.com/cihub/seelog"
+)
+
+// redis 列表消息来源：BRPOPLPUSH 将消息移入本实例的处理中列表，处理完成后从处理中列表删除，保证消息至少被处理一次。
+// 各实例定期在实例表中登记心跳，启动时将本实例上次未处理完成的消息放回列表，
+// 并将心跳超过 REDIS_LIST_INSTANCE_TIMEOUT 秒的实例的处理中列表放回列表
+type RedisList struct {
+	key           string         // 上报列表
+	instance      string         // 实例名称
+	processingKey string         // 本实例的处理中列表
+	instancesKey  string         // 实例表，实例名称 -> 最近一次心跳时间戳
+	pipeline      *Pipeline      // 上报状态处理流水线
+	pending       int64          // 处理中的消息数
+	chanExit      chan struct{}  // 携程退出消息通道
+	wg            sync.WaitGroup // 等待接收协程退出
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewRedisList(key, instance string, pipeline *Pipeline) *RedisList {
+	return &RedisList{
+		key:           key,
+		instance:      instance,
+		processingKey: redisListProcessingKey(key, instance),
+		instancesKey:  key + ":instances",
+		pipeline:      pipeline,
+		chanExit:      make(chan struct{}),
+	}
+}
+
+func (this *RedisList) Start(ctx context.Context) error {
+	if err := this.heartbeat(); err != nil {
+		return err
+	}
+	if n, err := this.requeue(this.processingKey); err != nil {
+		return err
+	} else if n > 0 {
+		seelog.Warnf("redis list source requeue %d msgs unfinished before restart", n)
+	}
+	this.recoverDead()
+
+	this.wg.Add(1)
+	go this.receiver()
+
+	return nil
+}
+
+// 停止接收消息
+func (this *RedisList) Stop(ctx context.Context) error {
+	close(this.chanExit)
+	this.wg.Wait()
+
+	return nil
+}
+
+// 等待已接收的消息完成，全部完成时从实例表中删除本实例；未完成的消息由本实例下次启动时，
+// 或由其它实例在本实例心跳超时后重新处理
+func (this *RedisList) Close(ctx context.Context) error {
+	if pending := waitPending(ctx, this.Pending); pending > 0 {
+		seelog.Warnf("stop redis list source with %d msgs unfinished, they will be consumed again", pending)
+		return nil
+	}
+
+	return redis.Hdel(this.instancesKey, this.instance)
+}
+
+func (this *RedisList) Pending() int {
+	return int(atomic.LoadInt64(&this.pending))
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 在实例表中登记本实例的心跳
+func (this *RedisList) heartbeat() error {
+	_, err := redis.Hset(this.instancesKey, this.instance, []byte(strconv.FormatInt(time.Now().Unix(), 10)))
+	return err
+}
+
+// 将心跳超时的实例的处理中列表放回上报列表，并从实例表中删除这些实例
+func (this *RedisList) recoverDead() {
+	instances, err := redis.Hgetall(this.instancesKey)
+	if err != nil {
+		seelog.Errorf("redis list source get instances err: %v", err)
+		return
+	}
+
+	now := time.Now().Unix()
+	for instance, value := range instances {
+		beat, _ := strconv.ParseInt(value, 10, 64)
+		if instance == this.instance || now-beat < model.REDIS_LIST_INSTANCE_TIMEOUT {
+			continue
+		}
+
+		n, err := this.requeue(redisListProcessingKey(this.key, instance))
+		if err != nil {
+			seelog.Errorf("redis list source recover instance %s err: %v", instance, err)
+			continue
+		}
+		if err = redis.Hdel(this.instancesKey, instance); err != nil {
+			seelog.Errorf("redis list source delete instance %s err: %v", instance, err)
+		}
+		seelog.Warnf("redis list source recover %d msgs of instance %s, last heartbeat %d", n, instance, beat)
+	}
+}
+
+// 将处理中列表的消息放回上报列表，返回放回的消息数
+func (this *RedisList) requeue(processingKey string) (int, error) {
+	for n := 0; ; n++ {
+		value, err := redis.Rpoplpush(processingKey, this.key)
+		if err != nil {
+			return n, err
+		}
+		if value == nil {
+			return n, nil
+		}
+	}
+}
+
+func (this *RedisList) receiver() {
+	defer this.wg.Done()
+
+	lastBeat := time.Now()
+	for {
+		select {
+		case <-this.chanExit:
+			return
+		default:
+		}
+
+		if time.Since(lastBeat) >= model.REDIS_LIST_HEARTBEAT_INTERVAL*time.Second {
+			lastBeat = time.Now()
+			if err := this.heartbeat(); err != nil {
+				seelog.Errorf("redis list source heartbeat err: %v", err)
+			}
+			this.recoverDead()
+		}
+
+		value, err := redis.Brpoplpush(this.key, this.processingKey, 1)
+		if err != nil {
+			seelog.Errorf("redis list source pop err: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if value == nil {
+			continue
+		}
+
+		atomic.AddInt64(&this.pending, 1)
+		t := newTicket("redis:"+this.key, value, func() {
+			if err := redis.Lrem(this.processingKey, 1, value); err != nil {
+				seelog.Errorf("redis list source ack err: %v", err)
+			}
+			atomic.AddInt64(&this.pending, -1)
+		})
+		if err = this.pipeline.Submit(t); err != nil {
+			seelog.Errorf("submit redis list msg err: %v", err)
+			atomic.AddInt64(&this.pending, -1)
+			return
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func redisListProcessingKey(key, instance string) string {
+	return key + ":processing:" + instance
+}
@@ -0,0 +1,31 @@
+package business
+
+import (
+	"state_monitor/model/redis"
+)
+
+// redis 发布投递目标：PUBLISH 报警 JSON 到指定频道，没有订阅者时报警丢失
+type redisPublishSink struct {
+	channel string // 发布频道
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newRedisPublishSink(channel string) *redisPublishSink {
+	return &redisPublishSink{
+		channel: channel,
+	}
+}
+
+func (this *redisPublishSink) Name() string {
+	return "redis_publish:" + this.channel
+}
+
+func (this *redisPublishSink) Send(obj *alarmRequest, value []byte, done sinkCallback) {
+	_, err := redis.Publish(this.channel, value)
+	done(this.channel, -1, -1, err)
+}
+
+func (this *redisPublishSink) Close() error {
+	return nil
+}
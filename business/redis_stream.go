@@ -0,0 +1,231 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"state_monitor/model"
+	"state_monitor/model/redis"
+
+	"github.com/cihub/seelog"
+)
+
+// redis stream 消息来源：以消费组方式读取上报状态（消息字段 data 为上报状态 JSON），处理完成后 XACK；
+// 启动时重新处理本消费者未确认的消息，定期将其它消费者（包括已退出的实例）长时间未确认的消息 XCLAIM 到本消费者
+type RedisStream struct {
+	key       string         // 上报 stream
+	group     string         // 消费组
+	consumer  string         // 消费者名称，即实例名称，各实例唯一
+	claimIdle time.Duration  // 其它消费者的消息超过该时间未确认时转移给本消费者
+	pipeline  *Pipeline      // 上报状态处理流水线
+	pending   int64          // 处理中的消息数
+	chanExit  chan struct{}  // 携程退出消息通道
+	wg        sync.WaitGroup // 等待接收协程退出
+}
+
+// 投递报警到 redis stream，报警 JSON 写入消息字段 data
+type redisStreamSink struct {
+	key    string // 报警 stream
+	maxLen int    // stream 最大长度（近似），0 表示不限制
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewRedisStream(key, group, consumer string, claimIdle time.Duration, pipeline *Pipeline) *RedisStream {
+	return &RedisStream{
+		key:       key,
+		group:     group,
+		consumer:  consumer,
+		claimIdle: claimIdle,
+		pipeline:  pipeline,
+		chanExit:  make(chan struct{}),
+	}
+}
+
+func (this *RedisStream) Start(ctx context.Context) error {
+	if err := redis.XgroupCreate(this.key, this.group); err != nil {
+		return err
+	}
+
+	this.wg.Add(1)
+	go this.receiver()
+
+	return nil
+}
+
+// 停止接收消息
+func (this *RedisStream) Stop(ctx context.Context) error {
+	close(this.chanExit)
+	this.wg.Wait()
+
+	return nil
+}
+
+// 等待已接收的消息完成，未确认的消息下次启动时重新处理
+func (this *RedisStream) Close(ctx context.Context) error {
+	if pending := waitPending(ctx, this.Pending); pending > 0 {
+		seelog.Warnf("stop redis stream source with %d msgs unacked, they will be consumed again", pending)
+	}
+
+	return nil
+}
+
+func (this *RedisStream) Pending() int {
+	return int(atomic.LoadInt64(&this.pending))
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *RedisStream) receiver() {
+	defer this.wg.Done()
+
+	if !this.recover() {
+		return
+	}
+
+	ticker := time.NewTicker(this.claimIdle)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.chanExit:
+			return
+		case <-ticker.C:
+			if !this.claim() {
+				return
+			}
+		default:
+		}
+
+		entries, err := redis.XreadGroup(this.key, this.group, this.consumer, ">",
+			model.REDIS_STREAM_READ_COUNT, model.REDIS_STREAM_BLOCK_TIME)
+		if err != nil {
+			seelog.Errorf("redis stream source read err: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if !this.submit(entries) {
+			return
+		}
+	}
+}
+
+// 分页重新处理重启前投递给本消费者的未确认消息，流水线已停止时返回 false
+func (this *RedisStream) recover() bool {
+	start := "0"
+	for {
+		select {
+		case <-this.chanExit:
+			return true
+		default:
+		}
+
+		entries, err := redis.XreadGroup(this.key, this.group, this.consumer, start, model.REDIS_STREAM_READ_COUNT, 0)
+		if err != nil {
+			seelog.Errorf("redis stream source read pending err: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if len(entries) == 0 {
+			return true
+		}
+		if !this.submit(entries) {
+			return false
+		}
+		start = entries[len(entries)-1].ID
+	}
+}
+
+// 分页遍历消费组的未确认消息，将其它消费者长时间未确认的消息转移给本消费者并提交，流水线已停止时返回 false
+func (this *RedisStream) claim() bool {
+	minIdle := int64(this.claimIdle / time.Millisecond)
+	start := "-"
+	for {
+		pendings, err := redis.Xpending(this.key, this.group, start, model.REDIS_STREAM_READ_COUNT)
+		if err != nil {
+			seelog.Errorf("redis stream source pending err: %v", err)
+			return true
+		}
+		if len(pendings) == 0 {
+			return true
+		}
+
+		ids := make([]string, 0, len(pendings))
+		for _, pending := range pendings {
+			if pending.Consumer != this.consumer && pending.Idle >= minIdle {
+				ids = append(ids, pending.ID)
+			}
+		}
+		if len(ids) > 0 {
+			entries, err := redis.Xclaim(this.key, this.group, this.consumer, minIdle, ids...)
+			if err != nil {
+				seelog.Errorf("redis stream source claim err: %v", err)
+				return true
+			}
+			if len(entries) > 0 {
+				seelog.Warnf("redis stream source claim %d entries from other consumers", len(entries))
+			}
+			if !this.submit(entries) {
+				return false
+			}
+		}
+
+		if len(pendings) < model.REDIS_STREAM_READ_COUNT {
+			return true
+		}
+		start = redis.NextStreamID(pendings[len(pendings)-1].ID)
+	}
+}
+
+// 提交消息到流水线，流水线已停止时返回 false
+func (this *RedisStream) submit(entries []*redis.StreamEntry) bool {
+	for _, entry := range entries {
+		id := entry.ID
+		atomic.AddInt64(&this.pending, 1)
+		t := newTicket(fmt.Sprintf("redis:%s#%s", this.key, id), entry.Fields[model.REDIS_STREAM_FIELD], func() {
+			if err := redis.Xack(this.key, this.group, id); err != nil {
+				seelog.Errorf("redis stream source ack %s err: %v", id, err)
+			}
+			atomic.AddInt64(&this.pending, -1)
+		})
+		if err := this.pipeline.Submit(t); err != nil {
+			seelog.Errorf("submit redis stream msg err: %v", err)
+			atomic.AddInt64(&this.pending, -1)
+			return false
+		}
+	}
+
+	return true
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newRedisStreamSink(key string, maxLen int) *redisStreamSink {
+	return &redisStreamSink{
+		key:    key,
+		maxLen: maxLen,
+	}
+}
+
+func (this *redisStreamSink) Name() string {
+	return "redis_stream:" + this.key
+}
+
+func (this *redisStreamSink) Send(obj *alarmRequest, value []byte, done sinkCallback) {
+	fields := map[string][]byte{
+		model.REDIS_STREAM_FIELD: value,
+	}
+	if _, err := redis.Xadd(this.key, this.maxLen, fields); err != nil {
+		done(this.key, -1, -1, err)
+		return
+	}
+
+	done(this.key, -1, -1, nil)
+}
+
+func (this *redisStreamSink) Close() error {
+	return nil
+}
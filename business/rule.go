@@ -0,0 +1,264 @@
+package business
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"state_monitor/business/expr"
+	"state_monitor/model"
+
+	"github.com/cihub/seelog"
+)
+
+// 监控策略中的自定义规则：fields 中以 rule. 为前缀的字段，字段名去掉前缀即规则名，字段值为规则表达式
+const policy_rule_prefix = "rule."
+
+type namedRule struct {
+	name string     // 规则名
+	expr *expr.Expr // 编译后的规则表达式
+}
+
+type ruleSet struct {
+	timestamp string       // 策略缓存的时间戳，策略重新加载后规则需要重新编译
+	rules     []*namedRule // 策略中的自定义规则
+}
+
+type ruleCache struct {
+	l    sync.RWMutex
+	sets map[string]*ruleSet
+}
+
+// 规则表达式的求值环境，prev.xxx 引用服务上一次的上报内容
+type stateEnv struct {
+	cur  *ReceiverStateMsg
+	prev *ReceiverStateMsg
+}
+
+var (
+	rules       *ruleCache     // 编译后的自定义规则，与 Redis 中缓存的策略一一对应
+	stateFields map[string]int // 可被规则引用的字段，key 为 json 字段名，value 为结构体字段下标
+)
+
+func init() {
+	rules = &ruleCache{
+		sets: make(map[string]*ruleSet),
+	}
+
+	stateFields = make(map[string]int)
+	t := reflect.TypeOf(ReceiverStateMsg{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		stateFields[name] = i
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *stateEnv) Lookup(name string) (interface{}, bool) {
+	obj := this.cur
+	if strings.HasPrefix(name, "prev.") {
+		obj = this.prev
+		name = name[len("prev."):]
+	}
+	if obj == nil {
+		return nil, false
+	}
+
+	index, ok := stateFields[name]
+	if !ok {
+		return nil, false
+	}
+
+	v := reflect.ValueOf(obj).Elem().Field(index)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		return v.Bool(), true
+	}
+
+	return nil, false
+}
+
+// 可被规则引用的字段的类型
+func stateFieldType(name string) (expr.Type, bool) {
+	index, ok := stateFields[strings.TrimPrefix(name, "prev.")]
+	if !ok {
+		return expr.Any, false
+	}
+
+	switch reflect.TypeOf(ReceiverStateMsg{}).Field(index).Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+		return expr.Number, true
+	case reflect.String:
+		return expr.String, true
+	case reflect.Bool:
+		return expr.Bool, true
+	}
+
+	return expr.Any, false
+}
+
+// 获取策略中编译后的自定义规则，表达式非法的规则在编译时记录日志并忽略
+func (this *ruleCache) get(jobId int64, serviceName string, m map[string]string) []*namedRule {
+	if m == nil {
+		return nil
+	}
+
+	key := fmt.Sprintf("%d#%s", jobId, serviceName)
+	timestamp := m["timestamp"]
+
+	this.l.RLock()
+	set, ok := this.sets[key]
+	this.l.RUnlock()
+	if ok && set.timestamp == timestamp {
+		return set.rules
+	}
+
+	set = &ruleSet{
+		timestamp: timestamp,
+		rules:     make([]*namedRule, 0),
+	}
+	for k, v := range m {
+		if !strings.HasPrefix(k, policy_rule_prefix) {
+			continue
+		}
+		name := k[len(policy_rule_prefix):]
+		if err := checkRuleName(name); err != nil {
+			seelog.Errorf("invalid rule %s of [%s]: %v", k, key, err)
+			continue
+		}
+		e, err := expr.Compile(v, stateFieldType)
+		if err != nil {
+			seelog.Errorf("invalid rule %s of [%s]: %v", k, key, err)
+			continue
+		}
+		set.rules = append(set.rules, &namedRule{name: name, expr: e})
+	}
+	sort.Slice(set.rules, func(i, j int) bool {
+		return set.rules[i].name < set.rules[j].name
+	})
+
+	this.l.Lock()
+	this.sets[key] = set
+	this.l.Unlock()
+
+	return set.rules
+}
+
+func (this *ruleCache) delete(jobId int64, serviceName string) {
+	this.l.Lock()
+	delete(this.sets, fmt.Sprintf("%d#%s", jobId, serviceName))
+	this.l.Unlock()
+}
+
+// 自定义规则名不能与内置规则重名
+func checkRuleName(name string) error {
+	switch name {
+	case "":
+		return fmt.Errorf("rule name is empty")
+	case model.ALARM_RULE_MEMORY, model.ALARM_RULE_LOAD, model.ALARM_RULE_NET_IN, model.ALARM_RULE_NET_OUT,
+		model.ALARM_RULE_STATUS, model.ALARM_RULE_EXIT_CODE, model.ALARM_RULE_HEARTBEAT:
+		return fmt.Errorf("rule name %s is reserved", name)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 记录服务本次上报内容，返回上一次的上报内容
+func (this *Pipeline) keepPrevious(stateObj *ReceiverStateMsg) *ReceiverStateMsg {
+	if stateObj == nil || stateObj.ServiceName == "" {
+		return nil
+	}
+
+	var prev *ReceiverStateMsg
+	value, err := this.previousModel.Get(stateObj.JobID, stateObj.ServiceName)
+	if err != nil {
+		seelog.Errorf("get previous report err: %v", err)
+	} else if value != nil {
+		prev = &ReceiverStateMsg{}
+		if err = json.Unmarshal(value, prev); err != nil {
+			prev = nil
+		}
+	}
+
+	// 上一次上报内容存于 redis，分区被分配到其它消费者后仍然可用；分区收回前未提交的消息会被新的消费者
+	// 重新消费，同一进程较旧的上报不覆盖已记录的内容，也没有可比较的上一次上报
+	if prev != nil && prev.ProcessID == stateObj.ProcessID && prev.HeartTime > stateObj.HeartTime {
+		seelog.Debugf("report of [%d#%s] at %d is older than previous report at %d, keep previous",
+			stateObj.JobID, stateObj.ServiceName, stateObj.HeartTime, prev.HeartTime)
+		return nil
+	}
+
+	// service exit, the next report belongs to a new process
+	if stateObj.ExitCode >= model.REPORT_STATE_COM_EXIT_CODE_EXIT_OK {
+		if err = this.previousModel.Delete(stateObj.JobID, stateObj.ServiceName); err != nil {
+			seelog.Errorf("delete previous report err: %v", err)
+		}
+		return prev
+	}
+
+	value, _ = json.Marshal(stateObj)
+	if err = this.previousModel.Set(stateObj.JobID, stateObj.ServiceName, value); err != nil {
+		seelog.Errorf("set previous report err: %v", err)
+	}
+
+	return prev
+}
+
+// 对上报消息执行策略中的自定义规则，返回命中的规则
+func (this *Pipeline) evalRules(stateObj, prev *ReceiverStateMsg, m map[string]string) []*namedRule {
+	if stateObj == nil || stateObj.ServiceName == "" {
+		return nil
+	}
+
+	env := &stateEnv{cur: stateObj, prev: prev}
+	hits := make([]*namedRule, 0)
+	for _, rule := range rules.get(stateObj.JobID, stateObj.ServiceName, m) {
+		isHit, err := rule.expr.EvalBool(env)
+		if err != nil {
+			seelog.Debugf("eval rule %s [%s] of [%d#%s] err: %v",
+				rule.name, rule.expr, stateObj.JobID, stateObj.ServiceName, err)
+			continue
+		}
+		if isHit {
+			hits = append(hits, rule)
+		}
+	}
+
+	return hits
+}
+
+// 根据同一进程的上一次上报计算网络流入、流出速率（字节/秒），net_in/net_out 为累加值，
+// 进程变化或计数器变小说明计数器已重置，此时无法计算速率
+func netRate(stateObj, prev *ReceiverStateMsg) (float64, float64, bool) {
+	if stateObj == nil || prev == nil || stateObj.ProcessID != prev.ProcessID {
+		return 0, 0, false
+	}
+
+	elapsed := stateObj.HeartTime - prev.HeartTime
+	if elapsed <= 0 {
+		return 0, 0, false
+	}
+
+	if stateObj.NetIn < prev.NetIn || stateObj.NetOut < prev.NetOut {
+		return 0, 0, false
+	}
+
+	netIn := float64(stateObj.NetIn-prev.NetIn) / float64(elapsed)
+	netOut := float64(stateObj.NetOut-prev.NetOut) / float64(elapsed)
+
+	return netIn, netOut, true
+}
@@ -0,0 +1,136 @@
+package scram
+
+import (
+	"crypto/sha1"
+	"hash"
+	"strings"
+	"testing"
+)
+
+// RFC 5802 第 5 节与 RFC 7677 第 3 节的示例交换
+var vectors = []struct {
+	name        string
+	hash        func() hash.Hash
+	nonce       string
+	clientFirst string
+	serverFirst string
+	clientFinal string
+	serverFinal string
+}{
+	{
+		name:        "SCRAM-SHA-1",
+		hash:        sha1.New,
+		nonce:       "fyko+d2lbbFgONRv9qkxdawL",
+		clientFirst: "n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL",
+		serverFirst: "r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096",
+		clientFinal: "c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=",
+		serverFinal: "v=rmF9pqV8S7suAoZWja4dJRkFsKQ=",
+	},
+	{
+		name:        "SCRAM-SHA-256",
+		hash:        SHA256,
+		nonce:       "rOprNGfwEbeRWgbNEkqO",
+		clientFirst: "n,,n=user,r=rOprNGfwEbeRWgbNEkqO",
+		serverFirst: "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		clientFinal: "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0," +
+			"p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		serverFinal: "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=",
+	},
+}
+
+// 按示例开始一次交换：替换随机生成的客户端随机数，并完成第一步
+func begin(t *testing.T, hash func() hash.Hash, nonce string) (*Client, string) {
+	c := NewClient(hash)
+	if err := c.Begin("user", "pencil", ""); err != nil {
+		t.Fatalf("Begin err: %v", err)
+	}
+	c.nonce = nonce
+
+	msg, err := c.Step("")
+	if err != nil {
+		t.Fatalf("client first err: %v", err)
+	}
+	return c, msg
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		c, msg := begin(t, v.hash, v.nonce)
+		if msg != v.clientFirst {
+			t.Errorf("%s: client first = %s, want %s", v.name, msg, v.clientFirst)
+		}
+
+		msg, err := c.Step(v.serverFirst)
+		if err != nil {
+			t.Errorf("%s: client final err: %v", v.name, err)
+			continue
+		}
+		if msg != v.clientFinal {
+			t.Errorf("%s: client final = %s, want %s", v.name, msg, v.clientFinal)
+		}
+
+		if _, err = c.Step(v.serverFinal); err != nil {
+			t.Errorf("%s: server final err: %v", v.name, err)
+		}
+		if !c.Done() {
+			t.Errorf("%s: exchange is not done", v.name)
+		}
+	}
+}
+
+func TestServerFinalInvalid(t *testing.T) {
+	v := vectors[1]
+	cases := []struct {
+		serverFinal string
+		err         string
+	}{
+		{"v=rmF9pqV8S7suAoZWja4dJRkFsKQ=", "signature is invalid"},
+		{"v=!", "signature is invalid"},
+		{"e=invalid-proof", "server error: invalid-proof"},
+	}
+
+	for _, c := range cases {
+		client, _ := begin(t, v.hash, v.nonce)
+		if _, err := client.Step(v.serverFirst); err != nil {
+			t.Fatalf("client final err: %v", err)
+		}
+		_, err := client.Step(c.serverFinal)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("server final %s: err = %v, want err containing %q", c.serverFinal, err, c.err)
+		}
+	}
+}
+
+func TestServerFirstInvalid(t *testing.T) {
+	v := vectors[1]
+	cases := []struct {
+		serverFirst string
+		err         string
+	}{
+		{"r=other,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096", "nonce is invalid"},
+		{"r=rOprNGfwEbeRWgbNEkqO,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096", "nonce is invalid"},
+		{"r=rOprNGfwEbeRWgbNEkqOx,s=!,i=4096", "salt is invalid"},
+		{"r=rOprNGfwEbeRWgbNEkqOx,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=0", "iteration count is invalid"},
+	}
+
+	for _, c := range cases {
+		client, _ := begin(t, v.hash, v.nonce)
+		_, err := client.Step(c.serverFirst)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("server first %s: err = %v, want err containing %q", c.serverFirst, err, c.err)
+		}
+	}
+}
+
+func TestAuthzID(t *testing.T) {
+	c := NewClient(SHA256)
+	if err := c.Begin("u=1,x", "pencil", "admin"); err != nil {
+		t.Fatalf("Begin err: %v", err)
+	}
+	msg, _ := c.Step("")
+	if want := "n,a=admin,n=u=3D1=2Cx,r=" + c.nonce; msg != want {
+		t.Errorf("client first = %s, want %s", msg, want)
+	}
+}
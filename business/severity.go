@@ -0,0 +1,87 @@
+package business
+
+import (
+	"fmt"
+	"strconv"
+
+	"state_monitor/config"
+	"state_monitor/model"
+
+	"github.com/cihub/seelog"
+)
+
+// 规则的报警级别：优先使用策略中 severity.<rule> 的配置，否则按环境类型取默认级别
+func severityOf(m map[string]string, rule string, envType int) string {
+	if v, ok := m["severity."+rule]; ok && isSeverity(v) {
+		return v
+	}
+
+	switch envType {
+	case model.REPORT_STATE_COM_ENV_TYPE_PRO:
+		return model.ALARM_SEVERITY_CRITICAL
+	case model.REPORT_STATE_COM_ENV_TYPE_PRE:
+		return model.ALARM_SEVERITY_WARNING
+	default:
+		return model.ALARM_SEVERITY_INFO
+	}
+}
+
+func isSeverity(severity string) bool {
+	switch severity {
+	case model.ALARM_SEVERITY_INFO, model.ALARM_SEVERITY_WARNING, model.ALARM_SEVERITY_CRITICAL:
+		return true
+	}
+	return false
+}
+
+// 升级后的报警级别，critical 为最高级别
+func raiseSeverity(severity string) string {
+	switch severity {
+	case model.ALARM_SEVERITY_INFO:
+		return model.ALARM_SEVERITY_WARNING
+	default:
+		return model.ALARM_SEVERITY_CRITICAL
+	}
+}
+
+// 报警升级时长，单位秒：优先使用策略中 escalate_after 的配置，0 表示不升级
+func escalateAfter(m map[string]string) int64 {
+	after := int64(config.GetConfig().Service.AlarmEscalateAfter)
+	if v, ok := m["escalate_after"]; ok {
+		after, _ = strconv.ParseInt(v, 10, 64)
+	}
+	return after
+}
+
+// 报警持续时间超过升级时长后，以更高的级别再发送一次报警，每次报警过程只升级一次
+func (this *Pipeline) escalateAlarm(obj *alarmRequest, m map[string]string) {
+	after := escalateAfter(m)
+	if after <= 0 {
+		return
+	}
+
+	record, err := this.alarmStateModel.Get(obj.JobID, obj.ServiceName, obj.Rule)
+	if err != nil {
+		seelog.Errorf("get alarm state err: %v", err)
+		return
+	}
+	if record == nil || record.Escalated || obj.HeartTime-record.FiringTime < after {
+		return
+	}
+
+	isEscalated, err := this.alarmStateModel.Escalate(obj.JobID, obj.ServiceName, record)
+	if err != nil {
+		seelog.Errorf("escalate alarm state err: %v", err)
+		return
+	}
+	if !isEscalated {
+		return
+	}
+
+	escalated := *obj
+	escalated.FiringTime = record.FiringTime
+	escalated.Severity = raiseSeverity(obj.Severity)
+	escalated.Escalated = true
+	escalated.Content = fmt.Sprintf("%s (escalated, firing for %ds)", obj.Content, obj.HeartTime-record.FiringTime)
+	this.sendAlarm(&escalated)
+}
@@ -0,0 +1,171 @@
+package business
+
+import (
+	"errors"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"state_monitor/business/notify"
+	"state_monitor/config"
+
+	"github.com/cihub/seelog"
+)
+
+// 投递结果回调：dest 为投递目标（主题、地址等），partition/offset 只对 kafka 有效，其它为 -1
+type sinkCallback func(dest string, partition int32, offset int64, err error)
+
+// 报警投递目标：Send 可以异步投递，每次调用必须且只能回调一次 done
+type AlarmSink interface {
+	Name() string
+	Send(obj *alarmRequest, value []byte, done sinkCallback)
+	Close() error
+}
+
+// 带路由条件的报警投递目标，条件为空表示不限制
+type sinkRoute struct {
+	sink       AlarmSink       // 投递目标
+	severities map[string]bool // 报警级别
+	envTypes   map[int]bool    // 环境类型
+	services   []string        // 服务名称通配符
+	teams      map[string]bool // 负责团队，- 表示没有负责团队
+	template   string          // 报警内容模板名称
+}
+
+// 同步投递函数，返回不需要重试的错误时用 notify.Permanent 包装
+type sinkSender func(obj *alarmRequest, value []byte) error
+
+type sinkJob struct {
+	obj   *alarmRequest
+	value []byte
+	done  sinkCallback
+}
+
+// 异步投递目标：投递任务进入队列，由多个协程投递，失败时按指数退避重试
+type asyncSink struct {
+	name     string         // 目标名称
+	dest     string         // 投递目标
+	send     sinkSender     // 同步投递函数
+	retries  int            // 失败重试次数
+	pending  int64          // 未完成的投递数
+	chanJobs chan *sinkJob  // 投递任务通道
+	wg       sync.WaitGroup // 等待投递协程退出
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newSinkRoute(sink AlarmSink, cfg *config.Sink) (*sinkRoute, error) {
+	route := &sinkRoute{
+		sink:       sink,
+		severities: make(map[string]bool),
+		envTypes:   make(map[int]bool),
+		services:   make([]string, 0),
+		teams:      make(map[string]bool),
+		template:   cfg.Template,
+	}
+
+	for _, severity := range config.SplitList(cfg.Severity) {
+		if !isSeverity(severity) {
+			return nil, errors.New("sink severity " + severity + " is invalid")
+		}
+		route.severities[severity] = true
+	}
+	for _, envType := range cfg.EnvTypes {
+		route.envTypes[envType] = true
+	}
+	for _, pattern := range config.SplitList(cfg.Service) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New("sink service pattern " + pattern + " is invalid")
+		}
+		route.services = append(route.services, pattern)
+	}
+	for _, team := range config.SplitList(cfg.Team) {
+		route.teams[team] = true
+	}
+
+	return route, nil
+}
+
+// 报警是否匹配路由条件
+func (this *sinkRoute) match(obj *alarmRequest) bool {
+	if len(this.severities) > 0 && !this.severities[obj.Severity] {
+		return false
+	}
+	if len(this.envTypes) > 0 && !this.envTypes[obj.EnvType] {
+		return false
+	}
+	if len(this.teams) > 0 {
+		team := obj.Team
+		if team == "" {
+			team = "-"
+		}
+		if !this.teams[team] {
+			return false
+		}
+	}
+	if len(this.services) == 0 {
+		return true
+	}
+	for _, pattern := range this.services {
+		if ok, _ := path.Match(pattern, obj.ServiceName); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newAsyncSink(name, dest string, retries, workers int, send sinkSender) *asyncSink {
+	s := &asyncSink{
+		name:     name,
+		dest:     dest,
+		send:     send,
+		retries:  retries,
+		chanJobs: make(chan *sinkJob, 100),
+	}
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+
+	return s
+}
+
+func (this *asyncSink) Name() string {
+	return this.name
+}
+
+func (this *asyncSink) Send(obj *alarmRequest, value []byte, done sinkCallback) {
+	atomic.AddInt64(&this.pending, 1)
+	this.chanJobs <- &sinkJob{obj: obj, value: value, done: done}
+}
+
+// 未完成的投递数
+func (this *asyncSink) backlog() int {
+	return int(atomic.LoadInt64(&this.pending))
+}
+
+// 等待队列中的报警投递完成
+func (this *asyncSink) Close() error {
+	close(this.chanJobs)
+	this.wg.Wait()
+
+	return nil
+}
+
+func (this *asyncSink) worker() {
+	defer this.wg.Done()
+
+	for job := range this.chanJobs {
+		err := notify.Retry(this.retries, time.Second, func() error {
+			return this.send(job.obj, job.value)
+		}, func(n int, wait time.Duration, err error) {
+			seelog.Warnf("alarm sink %s retry %d after %v, err: %v", this.name, n, wait, err)
+		})
+		job.done(this.dest, -1, -1, err)
+		atomic.AddInt64(&this.pending, -1)
+	}
+}
@@ -0,0 +1,74 @@
+package business
+
+import (
+	"context"
+	"time"
+
+	"state_monitor/config"
+
+	"github.com/cihub/seelog"
+)
+
+// 等待已接收的消息完成，ctx 结束时返回未完成的消息数
+func waitPending(ctx context.Context, pending func() int) int {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		n := pending()
+		if n == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return n
+		case <-ticker.C:
+		}
+	}
+}
+
+// 按配置创建启用的消息来源
+func NewSources(pipeline *Pipeline) ([]Consumer, error) {
+	cfg := config.GetConfig()
+	sources := make([]Consumer, 0, 4)
+
+	if cfg.Sources.Kafka.Enable {
+		for i := 0; i < int(cfg.Service.CustomerNum); i++ {
+			kafka, err := NewKafka(&cfg.Kafka, pipeline)
+			if err != nil {
+				closeSources(sources)
+				return nil, err
+			}
+			sources = append(sources, kafka)
+		}
+	}
+
+	if cfg.Sources.Http.Enable {
+		sources = append(sources, NewHttp(cfg.Sources.Http.Addr, cfg.Sources.Http.Token, pipeline))
+	}
+
+	if cfg.Sources.Udp.Enable {
+		sources = append(sources, NewUdp(cfg.Sources.Udp.Addr, pipeline))
+	}
+
+	if cfg.Sources.RedisList.Enable {
+		sources = append(sources, NewRedisList(cfg.Sources.RedisList.Key, cfg.Service.InstanceId, pipeline))
+	}
+
+	if cfg.Sources.RedisStream.Enable {
+		stream := cfg.Sources.RedisStream
+		sources = append(sources, NewRedisStream(stream.Key, stream.Group, cfg.Service.InstanceId,
+			time.Duration(stream.ClaimIdle)*time.Second, pipeline))
+	}
+
+	return sources, nil
+}
+
+// 关闭已创建但未启动的消息来源
+func closeSources(sources []Consumer) {
+	for _, v := range sources {
+		if err := v.Close(context.Background()); err != nil {
+			seelog.Errorf("close source err: %v", err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package business
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// 消息处理凭证：消息处理完成、状态写入 MySQL、报警投递确认后才算处理完成，
+// 每个环节开始前 add，结束后 done，计数归零时回调消息来源确认消息（例如提交 kafka offset）
+type ticket struct {
+	source    string // 消息来源：kafka 主题、http、udp、redis 列表名称等
+	partition int32  // kafka 分区，其它来源为 -1
+	offset    int64  // kafka 偏移量，其它来源为 -1
+	key       []byte // 消息键
+	value     []byte // 消息内容
+	ack       func() // 处理完成回调，可以为 nil
+	pending   int32  // 未完成的环节数
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 创建消息处理凭证，凭证已包含消息处理环节
+func newTicket(source string, value []byte, ack func()) *ticket {
+	return &ticket{
+		source:    source,
+		partition: -1,
+		offset:    -1,
+		value:     value,
+		ack:       ack,
+		pending:   1,
+	}
+}
+
+// 增加未完成的环节，凭证为 nil 时（例如心跳扫描产生的报警）忽略
+func (this *ticket) add(n int32) {
+	if this == nil {
+		return
+	}
+	atomic.AddInt32(&this.pending, n)
+}
+
+// 完成一个环节
+func (this *ticket) done() {
+	if this == nil {
+		return
+	}
+	if atomic.AddInt32(&this.pending, -1) == 0 && this.ack != nil {
+		this.ack()
+	}
+}
+
+func (this *ticket) String() string {
+	return fmt.Sprintf("T:%s P:%d O:%d", this.source, this.partition, this.offset)
+}
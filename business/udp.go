@@ -0,0 +1,94 @@
+package business
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+
+	"github.com/cihub/seelog"
+)
+
+// udp 消息来源：每个数据报包含一行或多行上报状态，每行一个 JSON 对象
+type Udp struct {
+	addr     string         // 监听地址
+	pipeline *Pipeline      // 上报状态处理流水线
+	conn     net.PacketConn // 监听连接
+	chanExit chan struct{}  // 携程退出消息通道
+	wg       sync.WaitGroup // 等待接收协程退出
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewUdp(addr string, pipeline *Pipeline) *Udp {
+	return &Udp{
+		addr:     addr,
+		pipeline: pipeline,
+		chanExit: make(chan struct{}),
+	}
+}
+
+func (this *Udp) Start(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", this.addr)
+	if err != nil {
+		return err
+	}
+	this.conn = conn
+
+	this.wg.Add(1)
+	go this.receiver()
+
+	return nil
+}
+
+func (this *Udp) Stop(ctx context.Context) error {
+	close(this.chanExit)
+	err := this.conn.Close()
+	this.wg.Wait()
+
+	return err
+}
+
+// 数据报提交到流水线后即完成，没有需要等待的消息
+func (this *Udp) Close(ctx context.Context) error {
+	return nil
+}
+
+func (this *Udp) Pending() int {
+	return 0
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *Udp) receiver() {
+	defer this.wg.Done()
+
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := this.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-this.chanExit:
+				return
+			default:
+			}
+			seelog.Errorf("udp source read err: %v", err)
+			continue
+		}
+
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+
+			// buf is reused by the next read
+			value := make([]byte, len(line))
+			copy(value, line)
+			if err = this.pipeline.Submit(newTicket("udp:"+addr.String(), value, nil)); err != nil {
+				seelog.Errorf("submit udp msg err: %v", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package business
+
+import (
+	"time"
+
+	"state_monitor/business/notify"
+)
+
+// webhook 投递目标：POST 报警 JSON，网络错误、5xx 和 429 时重试
+func newWebhookSink(url, secret string, retries int, timeout time.Duration) *asyncSink {
+	webhook := notify.NewWebhook(url, secret, timeout)
+
+	return newAsyncSink("webhook:"+url, url, retries, 4, func(obj *alarmRequest, value []byte) error {
+		return webhook.Post(value)
+	})
+}
@@ -3,16 +3,30 @@ package config
 var currentConfig *Config
 
 type Config struct {
-	Service Service `xml:"service"`
-	Redis   Redis   `xml:"redis"`
-	Mysql   Mysql   `xml:"mysql"`
-	Kafka   Kafka   `xml:"kafka"`
+	Service         Service         `xml:"service"`
+	Redis           Redis           `xml:"redis"`
+	Mysql           Mysql           `xml:"mysql"`
+	Kafka           Kafka           `xml:"kafka"`
+	Sources         Sources         `xml:"sources"`
+	Sinks           Sinks           `xml:"sinks"`
+	DefaultPolicies []DefaultPolicy `xml:"default_policies>policy"`
+	AlarmTemplates  []AlarmTemplate `xml:"alarm_templates>template"`
 }
 
 type Service struct {
-	MaxStoreMonths uint32 `xml:"max_store_months"`
-	CustomerNum    uint32 `xml:"customer_num"`
-	JobPoolSize    uint32 `xml:"job_pool_size"`
+	MaxStoreMonths      uint32 `xml:"max_store_months"`
+	CustomerNum         uint32 `xml:"customer_num"`
+	JobPoolSize         uint32 `xml:"job_pool_size"`
+	HeartSweepInterval  uint32 `xml:"heart_sweep_interval"`
+	HeartMissNum        uint32 `xml:"heart_miss_num"`
+	AlarmSuppressWindow uint32 `xml:"alarm_suppress_window"`
+	AlarmEscalateAfter  uint32 `xml:"alarm_escalate_after"`
+	BatchInsertCaps     uint32 `xml:"batch_insert_caps"`
+	BatchInsertInterval uint32 `xml:"batch_insert_interval"`
+	BatchQueueCaps      uint32 `xml:"batch_queue_caps"`
+	BatchWriterNum      uint32 `xml:"batch_writer_num"`
+	ShutdownTimeout     uint32 `xml:"shutdown_timeout"`
+	InstanceId          string `xml:"instance_id"`
 }
 
 type Redis struct {
@@ -35,4 +49,116 @@ type Kafka struct {
 	Brokers            []string `xml:"broker"`
 	ReceiveStateTopics []string `xml:"receive_state_topic"`
 	SendAlarmTopic     string   `xml:"send_alarm_topic"`
+	EscalateAlarmTopic string   `xml:"escalate_alarm_topic"`
+	DeadLetterTopic    string   `xml:"dead_letter_topic"`
+
+	Version          string    `xml:"version"`           // 协议版本，默认 0.11.0.2
+	GroupID          string    `xml:"group_id"`          // 消费组，默认 state_monitor_center
+	InitialOffset    string    `xml:"initial_offset"`    // 消费组没有提交 offset 时的起始位置 newest/oldest，默认 newest
+	FetchMin         int32     `xml:"fetch_min"`         // 每次拉取的最小字节数，0 表示使用 sarama 默认值
+	FetchDefault     int32     `xml:"fetch_default"`     // 每次拉取的默认字节数，0 表示使用 sarama 默认值
+	FetchMax         int32     `xml:"fetch_max"`         // 每次拉取的最大字节数，0 表示不限制
+	RequiredAcks     string    `xml:"required_acks"`     // 生产者确认方式 all/local/none，默认 all
+	Compression      string    `xml:"compression"`       // 生产者压缩方式 none/gzip/snappy/lz4/zstd，默认 none
+	Partitioner      string    `xml:"partitioner"`       // 生产者分区方式 hash/random/round_robin，默认 hash
+	RetryMax         *int      `xml:"retry_max"`         // 生产者失败重试次数，0 表示不重试，默认 3
+	RetryBackoff     uint32    `xml:"retry_backoff"`     // 生产者重试间隔，单位毫秒，默认 100
+	RebalanceTimeout uint32    `xml:"rebalance_timeout"` // rebalance 时提交 offset 并释放分区前的等待时间，单位秒，默认 3
+	Tls              KafkaTls  `xml:"tls"`
+	Sasl             KafkaSasl `xml:"sasl"`
+}
+
+// kafka TLS：ca_file 为空时使用系统根证书，cert_file 和 key_file 同时配置时使用客户端证书
+type KafkaTls struct {
+	Enable             bool   `xml:"enable,attr"`
+	CaFile             string `xml:"ca_file"`
+	CertFile           string `xml:"cert_file"`
+	KeyFile            string `xml:"key_file"`
+	InsecureSkipVerify bool   `xml:"insecure_skip_verify"`
+}
+
+// kafka SASL：mechanism 为 PLAIN/SCRAM-SHA-256/SCRAM-SHA-512，默认 PLAIN，SCRAM 需要 kafka 1.0.0 以上
+type KafkaSasl struct {
+	Enable    bool   `xml:"enable,attr"`
+	Mechanism string `xml:"mechanism"`
+	User      string `xml:"user"`
+	Password  string `xml:"password"`
+}
+
+// 上报状态的消息来源，可以同时启用多个
+type Sources struct {
+	Kafka       Source `xml:"kafka"`
+	Http        Source `xml:"http"`
+	Udp         Source `xml:"udp"`
+	RedisList   Source `xml:"redis_list"`
+	RedisStream Source `xml:"redis_stream"`
+}
+
+type Source struct {
+	Enable bool   `xml:"enable,attr"`
+	Addr   string `xml:"addr"`  // http/udp 监听地址
+	Key    string `xml:"key"`   // redis 列表名称
+	Token  string `xml:"token"` // http 共享密钥，为空时不校验
+
+	Group     string `xml:"group"`      // redis stream 消费组
+	ClaimIdle uint32 `xml:"claim_idle"` // redis stream 其它消费者的消息超过该时间（秒）未确认时转移给本消费者
+}
+
+// 报警投递目标，可以同时启用多个，报警只投递到路由条件匹配的目标
+type Sinks struct {
+	Kafka         Sink   `xml:"kafka"`
+	KafkaTopics   []Sink `xml:"kafka_topic"`
+	RedisStream   Sink   `xml:"redis_stream"`
+	RedisPublishs []Sink `xml:"redis_publish"`
+	Webhooks      []Sink `xml:"webhook"`
+	Emails        []Sink `xml:"email"`
+	Files         []Sink `xml:"file"`
+}
+
+// 路由条件均为逗号分隔的列表，为空表示不限制
+type Sink struct {
+	Enable   bool   `xml:"enable,attr"`
+	Severity string `xml:"severity,attr"` // 路由：报警级别
+	Env      string `xml:"env,attr"`      // 路由：环境 dev/test/ide/pre/pro
+	Service  string `xml:"service,attr"`  // 路由：服务名称通配符，例如 order_*
+	Team     string `xml:"team,attr"`     // 路由：负责团队，- 表示没有负责团队的报警
+	Template string `xml:"template,attr"` // 报警内容模板名称，为空时使用默认模板
+
+	Topic    string   `xml:"topic"`    // kafka 主题
+	Key      string   `xml:"key"`      // redis stream 名称
+	MaxLen   int      `xml:"max_len"`  // redis stream 最大长度（近似），0 表示不限制
+	Channel  string   `xml:"channel"`  // redis 发布频道
+	Url      string   `xml:"url"`      // webhook 地址
+	Secret   string   `xml:"secret"`   // webhook HMAC-SHA256 签名密钥，为空时不签名
+	Retries  *uint32  `xml:"retries"`  // webhook/email 失败重试次数，0 表示不重试，默认 3
+	Timeout  uint32   `xml:"timeout"`  // webhook/email 超时时间，单位秒
+	Host     string   `xml:"host"`     // smtp 主机
+	Port     int      `xml:"port"`     // smtp 端口
+	User     string   `xml:"user"`     // smtp 用户，为空时不认证
+	Password string   `xml:"password"` // smtp 密码
+	From     string   `xml:"from"`     // 发件人
+	To       []string `xml:"to"`       // 收件人
+	Path     string   `xml:"path"`     // JSONL 文件路径
+
+	EnvTypes []int `xml:"-"`
+}
+
+// 默认监控策略：服务没有配置监控策略时使用，env 为空表示所有环境，prefix 为空表示所有服务
+type DefaultPolicy struct {
+	Env     string        `xml:"env,attr"`
+	Prefix  string        `xml:"prefix,attr"`
+	Fields  []PolicyField `xml:"field"`
+	EnvType int           `xml:"-"`
+}
+
+type PolicyField struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// 报警内容模板（text/template）：name 为空表示默认模板，rule 为空表示所有规则
+type AlarmTemplate struct {
+	Name string `xml:"name,attr"`
+	Rule string `xml:"rule,attr"`
+	Text string `xml:",chardata"`
 }
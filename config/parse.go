@@ -7,9 +7,20 @@ import (
 	"os"
 	"strings"
 
+	"github.com/Shopify/sarama"
 	"github.com/cihub/seelog"
 )
 
+// 与 report_state.env_type 一致
+var envTypes = map[string]int{
+	"":     -1,
+	"dev":  0,
+	"test": 1,
+	"ide":  2,
+	"pre":  3,
+	"pro":  4,
+}
+
 func init() {
 	if err := loadConfig(); err != nil {
 		seelog.Errorf("load config err: %v", err)
@@ -28,6 +39,17 @@ func GetConfig() *Config {
 	return currentConfig
 }
 
+// 解析逗号分隔的列表，忽略空白项；配置项和数据库字段中的列表都使用该格式
+func SplitList(s string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func loadConfig() error {
@@ -66,6 +88,10 @@ func checkConfig() error {
 		currentConfig.Mysql.Port,
 		currentConfig.Mysql.DbName)
 
+	if err := checkKafka(&currentConfig.Kafka); err != nil {
+		return err
+	}
+
 	// customer > 0
 	if currentConfig.Service.CustomerNum == 0 {
 		currentConfig.Service.CustomerNum = 1
@@ -76,6 +102,292 @@ func checkConfig() error {
 		currentConfig.Service.JobPoolSize = 1
 	}
 
+	// heartbeat sweep interval > 0
+	if currentConfig.Service.HeartSweepInterval == 0 {
+		currentConfig.Service.HeartSweepInterval = 30
+	}
+
+	// heartbeat miss num > 0
+	if currentConfig.Service.HeartMissNum == 0 {
+		currentConfig.Service.HeartMissNum = 3
+	}
+
+	// default policy env name to env type, -1 means all env
+	for i := range currentConfig.DefaultPolicies {
+		policy := &currentConfig.DefaultPolicies[i]
+		envType, ok := envTypes[strings.ToLower(policy.Env)]
+		if !ok {
+			return fmt.Errorf("default policy env %q is invalid", policy.Env)
+		}
+		policy.EnvType = envType
+	}
+
+	// alarm template text is required, it is parsed when the pipeline is created
+	templates := make(map[[2]string]bool)
+	for i := range currentConfig.AlarmTemplates {
+		tpl := &currentConfig.AlarmTemplates[i]
+		tpl.Text = strings.TrimSpace(tpl.Text)
+		if tpl.Text == "" {
+			return fmt.Errorf("alarm template [name: %q, rule: %q] is empty", tpl.Name, tpl.Rule)
+		}
+		if templates[[2]string{tpl.Name, tpl.Rule}] {
+			return fmt.Errorf("alarm template [name: %q, rule: %q] is duplicated", tpl.Name, tpl.Rule)
+		}
+		templates[[2]string{tpl.Name, tpl.Rule}] = true
+	}
+
+	// kafka is the default source
+	sources := &currentConfig.Sources
+	if !sources.Kafka.Enable && !sources.Http.Enable && !sources.Udp.Enable && !sources.RedisList.Enable &&
+		!sources.RedisStream.Enable {
+		sources.Kafka.Enable = true
+	}
+	if sources.Http.Addr == "" {
+		sources.Http.Addr = ":8080"
+	}
+	if sources.Udp.Addr == "" {
+		sources.Udp.Addr = ":8125"
+	}
+	if sources.RedisList.Key == "" {
+		sources.RedisList.Key = "monitor:state:report"
+	}
+	if sources.RedisStream.Key == "" {
+		sources.RedisStream.Key = "monitor:state:report_stream"
+	}
+	if sources.RedisStream.Group == "" {
+		sources.RedisStream.Group = "state_monitor_center"
+	}
+	if sources.RedisStream.ClaimIdle == 0 {
+		sources.RedisStream.ClaimIdle = 60
+	}
+
+	// kafka is the default alarm sink
+	sinks := &currentConfig.Sinks
+	if sinks.RedisStream.Key == "" {
+		sinks.RedisStream.Key = "monitor:state:alarm_stream"
+	}
+	if err := checkSinks(sinks); err != nil {
+		return err
+	}
+	if sinks.Kafka.Enable && currentConfig.Kafka.SendAlarmTopic == "" {
+		return fmt.Errorf("kafka send_alarm_topic is empty, it is required by the kafka sink")
+	}
+
+	// alarm suppress window > 0
+	if currentConfig.Service.AlarmSuppressWindow == 0 {
+		currentConfig.Service.AlarmSuppressWindow = 300
+	}
+
+	// batch insert caps > 0
+	if currentConfig.Service.BatchInsertCaps == 0 {
+		currentConfig.Service.BatchInsertCaps = 200
+	}
+
+	// batch insert interval > 0
+	if currentConfig.Service.BatchInsertInterval == 0 {
+		currentConfig.Service.BatchInsertInterval = 90
+	}
+
+	// batch queue caps > 0
+	if currentConfig.Service.BatchQueueCaps == 0 {
+		currentConfig.Service.BatchQueueCaps = 1000
+	}
+
+	// batch writer > 0
+	if currentConfig.Service.BatchWriterNum == 0 {
+		currentConfig.Service.BatchWriterNum = 1
+	}
+
+	// shutdown deadline > 0
+	if currentConfig.Service.ShutdownTimeout == 0 {
+		currentConfig.Service.ShutdownTimeout = 60
+	}
+
+	// instance id is unique among running instances
+	if currentConfig.Service.InstanceId == "" {
+		hostname, _ := os.Hostname()
+		currentConfig.Service.InstanceId = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	return nil
+}
+
+// 校验 kafka 客户端配置并设置默认值
+func checkKafka(kafka *Kafka) error {
+	if kafka.Version == "" {
+		kafka.Version = "0.11.0.2"
+	}
+	version, err := sarama.ParseKafkaVersion(kafka.Version)
+	if err != nil {
+		return fmt.Errorf("kafka version %q is invalid", kafka.Version)
+	}
+	if !version.IsAtLeast(sarama.V0_11_0_0) {
+		return fmt.Errorf("kafka version must be >= 0.11.0 for alarm and dead letter headers")
+	}
+	if kafka.GroupID == "" {
+		kafka.GroupID = "state_monitor_center"
+	}
+
+	kafka.InitialOffset = strings.ToLower(kafka.InitialOffset)
+	if kafka.InitialOffset == "" {
+		kafka.InitialOffset = "newest"
+	}
+	if kafka.InitialOffset != "newest" && kafka.InitialOffset != "oldest" {
+		return fmt.Errorf("kafka initial_offset %q is invalid", kafka.InitialOffset)
+	}
+	if kafka.FetchMin < 0 || kafka.FetchDefault < 0 || kafka.FetchMax < 0 {
+		return fmt.Errorf("kafka fetch size must be >= 0")
+	}
+	if kafka.FetchMax > 0 && kafka.FetchDefault > kafka.FetchMax {
+		return fmt.Errorf("kafka fetch_default must be <= fetch_max")
+	}
+
+	kafka.RequiredAcks = strings.ToLower(kafka.RequiredAcks)
+	switch kafka.RequiredAcks {
+	case "":
+		kafka.RequiredAcks = "all"
+	case "all", "local", "none":
+	default:
+		return fmt.Errorf("kafka required_acks %q is invalid", kafka.RequiredAcks)
+	}
+
+	kafka.Compression = strings.ToLower(kafka.Compression)
+	switch kafka.Compression {
+	case "":
+		kafka.Compression = "none"
+	case "none", "gzip", "snappy", "lz4":
+	case "zstd":
+		if !version.IsAtLeast(sarama.V2_1_0_0) {
+			return fmt.Errorf("kafka compression zstd requires version >= 2.1.0")
+		}
+	default:
+		return fmt.Errorf("kafka compression %q is invalid", kafka.Compression)
+	}
+
+	kafka.Partitioner = strings.ToLower(kafka.Partitioner)
+	switch kafka.Partitioner {
+	case "":
+		kafka.Partitioner = "hash"
+	case "random", "hash", "round_robin":
+	default:
+		return fmt.Errorf("kafka partitioner %q is invalid", kafka.Partitioner)
+	}
+
+	if kafka.RetryMax == nil {
+		retryMax := 3
+		kafka.RetryMax = &retryMax
+	}
+	if *kafka.RetryMax < 0 {
+		return fmt.Errorf("kafka retry_max must be >= 0")
+	}
+	if kafka.RetryBackoff == 0 {
+		kafka.RetryBackoff = 100
+	}
+	if kafka.RebalanceTimeout == 0 {
+		kafka.RebalanceTimeout = 3
+	}
+	if kafka.RebalanceTimeout > 600 {
+		return fmt.Errorf("kafka rebalance_timeout must be <= 600")
+	}
+
+	if kafka.Tls.Enable {
+		if (kafka.Tls.CertFile == "") != (kafka.Tls.KeyFile == "") {
+			return fmt.Errorf("kafka tls cert_file and key_file must be set together")
+		}
+		for _, file := range []string{kafka.Tls.CaFile, kafka.Tls.CertFile, kafka.Tls.KeyFile} {
+			if _, err := os.Stat(file); file != "" && err != nil {
+				return fmt.Errorf("kafka tls file err: %v", err)
+			}
+		}
+	}
+
+	if kafka.Sasl.Enable {
+		kafka.Sasl.Mechanism = strings.ToUpper(kafka.Sasl.Mechanism)
+		switch kafka.Sasl.Mechanism {
+		case "":
+			kafka.Sasl.Mechanism = sarama.SASLTypePlaintext
+		case sarama.SASLTypePlaintext:
+		case sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+			if !version.IsAtLeast(sarama.V1_0_0_0) {
+				return fmt.Errorf("kafka sasl %s requires version >= 1.0.0", kafka.Sasl.Mechanism)
+			}
+		default:
+			return fmt.Errorf("kafka sasl mechanism %q is invalid", kafka.Sasl.Mechanism)
+		}
+		if kafka.Sasl.User == "" || kafka.Sasl.Password == "" {
+			return fmt.Errorf("kafka sasl user or password is empty")
+		}
+	}
+
+	return nil
+}
+
+// 校验报警投递目标，解析路由环境，没有启用任何目标时启用 kafka
+func checkSinks(sinks *Sinks) error {
+	all := []*Sink{&sinks.Kafka, &sinks.RedisStream}
+	for _, list := range [][]Sink{sinks.KafkaTopics, sinks.RedisPublishs, sinks.Webhooks, sinks.Emails, sinks.Files} {
+		for i := range list {
+			all = append(all, &list[i])
+		}
+	}
+
+	enabled := 0
+	for _, sink := range all {
+		if !sink.Enable {
+			continue
+		}
+		enabled++
+
+		sink.EnvTypes = sink.EnvTypes[:0]
+		for _, env := range SplitList(sink.Env) {
+			envType, ok := envTypes[strings.ToLower(env)]
+			if !ok || envType < 0 {
+				return fmt.Errorf("sink env %q is invalid", env)
+			}
+			sink.EnvTypes = append(sink.EnvTypes, envType)
+		}
+
+		if sink.Retries == nil {
+			retries := uint32(3)
+			sink.Retries = &retries
+		}
+		if sink.Timeout == 0 {
+			sink.Timeout = 5
+		}
+		if sink.Port == 0 {
+			sink.Port = 25
+		}
+	}
+	if enabled == 0 {
+		sinks.Kafka.Enable = true
+	}
+
+	for _, sink := range sinks.KafkaTopics {
+		if sink.Enable && sink.Topic == "" {
+			return fmt.Errorf("kafka_topic sink topic is empty")
+		}
+	}
+	for _, sink := range sinks.RedisPublishs {
+		if sink.Enable && sink.Channel == "" {
+			return fmt.Errorf("redis_publish sink channel is empty")
+		}
+	}
+	for _, sink := range sinks.Webhooks {
+		if sink.Enable && sink.Url == "" {
+			return fmt.Errorf("webhook sink url is empty")
+		}
+	}
+	for _, sink := range sinks.Emails {
+		if sink.Enable && (sink.Host == "" || sink.From == "" || len(sink.To) == 0) {
+			return fmt.Errorf("email sink host, from or to is empty")
+		}
+	}
+	for _, sink := range sinks.Files {
+		if sink.Enable && sink.Path == "" {
+			return fmt.Errorf("file sink path is empty")
+		}
+	}
+
 	return nil
 }
 
@@ -1,37 +1,56 @@
 package main
 
 import (
+	"flag"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"state_monitor/business"
 	"state_monitor/config"
 	"state_monitor/model/mysql"
+	"state_monitor/model/redis"
 	"state_monitor/server"
 
 	"github.com/cihub/seelog"
 )
 
+var (
+	replayDeadLetter = flag.Bool("replay_dead_letter", false, "re-inject dead letter messages into receive_state_topic and exit")
+	replayIdle       = flag.Duration("replay_idle", 10*time.Second, "stop replaying after no dead letter message for this duration")
+)
+
 func main() {
 	defer destroy()
+	flag.Parse()
 
-	s := server.NewServer()
 	cfg := config.GetConfig()
+	if *replayDeadLetter {
+		replay(cfg)
+		return
+	}
 
-	for i := 0; i < int(cfg.Service.CustomerNum); i++ {
-		kafka, err := business.NewKafka(
-			cfg.Kafka.Brokers,
-			cfg.Kafka.ReceiveStateTopics,
-			cfg.Kafka.SendAlarmTopic)
-		if err != nil {
-			seelog.Errorf("new kafka err: %v", err)
-			return
-		}
-		s.Kafkas = append(s.Kafkas, kafka)
+	s := server.NewServer()
+
+	pipeline, err := business.NewPipeline()
+	if err != nil {
+		seelog.Errorf("new pipeline err: %v", err)
+		return
 	}
+	s.Pipeline = pipeline
 
-	s.Start()
+	sources, err := business.NewSources(pipeline)
+	if err != nil {
+		seelog.Errorf("new sources err: %v", err)
+		return
+	}
+	s.Sources = sources
+
+	if err = s.Start(); err != nil {
+		seelog.Errorf("start server err: %v", err)
+		return
+	}
 
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, os.Kill, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
@@ -46,7 +65,24 @@ func main() {
 	s.Stop()
 }
 
+// 将死信主题中的消息重新投递到上报主题
+func replay(cfg *config.Config) {
+	if cfg.Kafka.DeadLetterTopic == "" || len(cfg.Kafka.ReceiveStateTopics) == 0 {
+		seelog.Errorf("dead_letter_topic or receive_state_topic is not configured")
+		return
+	}
+
+	count, err := business.ReplayDeadLetter(&cfg.Kafka, cfg.Kafka.ReceiveStateTopics, *replayIdle)
+	if err != nil {
+		seelog.Errorf("replay dead letter err: %v", err)
+	}
+	seelog.Infof("replay %d dead letter msgs", count)
+}
+
 func destroy() {
 	mysql.FreeDB()
+	if err := redis.Close(); err != nil {
+		seelog.Errorf("close redis err: %v", err)
+	}
 	seelog.Flush()
 }
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"state_monitor/model/redis"
+)
+
+// 报警去重：同一服务同一规则在抑制窗口内只发送一次报警，窗口内的重复报警只计数并保留最后一次报警，
+// 窗口结束时由 Digest 取出累计次数发送汇总报警并开始新的窗口，窗口内没有重复报警时去重记录结束
+type AlarmDedup struct{}
+
+// 抑制窗口内的汇总
+type AlarmDigest struct {
+	Alarm       []byte // 最后一次被抑制的报警 JSON
+	Occurrences int64  // 被抑制的报警次数
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewAlarmDedup() *AlarmDedup {
+	return &AlarmDedup{}
+}
+
+// 判断报警是否允许发送，允许发送时返回上次发送后累计的报警次数（包含本次），不允许发送时保留 alarm 用于汇总
+func (this *AlarmDedup) Allow(jobId int64, serviceName, rule string, window int64, alarm []byte) (bool, int64, error) {
+	if window <= 0 {
+		return true, 1, nil
+	}
+
+	windowKey := this.cacheKey(jobId, serviceName, rule)
+	countKey, lastKey := windowKey+":count", windowKey+":last"
+
+	// 抑制窗口已过期才能写入成功
+	reply, err := redis.Do("SET", windowKey, 1, "EX", window, "NX")
+	if err != nil {
+		return false, 0, err
+	}
+	if reply == nil {
+		if _, err = redis.Do("INCR", countKey); err != nil {
+			return false, 0, err
+		}
+		// 计数在不再报警的服务上不会被重置，过期后自动清除
+		if _, err = redis.Do("EXPIRE", countKey, window*2); err != nil {
+			return false, 0, err
+		}
+		if _, err = redis.Do("HSET", lastKey, "alarm", alarm, "window", window); err != nil {
+			return false, 0, err
+		}
+		if _, err = redis.Do("EXPIRE", lastKey, window*2); err != nil {
+			return false, 0, err
+		}
+		return false, 0, nil
+	}
+
+	reply, err = redis.Do("GETSET", countKey, 0)
+	if err != nil {
+		return true, 1, err
+	}
+	if _, err = redis.Do("EXPIRE", countKey, window*2); err != nil {
+		return true, 1, err
+	}
+	// 窗口结束时检查是否需要汇总
+	if _, err = redis.Do("ZADD", this.dueKey(), time.Now().Unix()+window, windowKey); err != nil {
+		return true, 1, err
+	}
+
+	return true, parseCount(reply) + 1, nil
+}
+
+// 返回抑制窗口已结束的去重记录
+func (this *AlarmDedup) Due(now int64) ([]string, error) {
+	return redis.Zrangebyscore(this.dueKey(), "-inf", now)
+}
+
+// 取出去重记录在窗口内的汇总：窗口内有重复报警时返回汇总并开始新的窗口，否则结束该去重记录并返回 nil
+func (this *AlarmDedup) Digest(windowKey string) (*AlarmDigest, error) {
+	countKey, lastKey := windowKey+":count", windowKey+":last"
+
+	reply, err := redis.Do("GETSET", countKey, 0)
+	if err != nil {
+		return nil, err
+	}
+	count := parseCount(reply)
+	if count == 0 {
+		_, err = redis.Do("ZREM", this.dueKey(), windowKey)
+		return nil, err
+	}
+
+	last, err := redis.Hgetall(lastKey)
+	if err != nil {
+		return nil, err
+	}
+	window, _ := strconv.ParseInt(last["window"], 10, 64)
+	if window <= 0 {
+		_, err = redis.Do("ZREM", this.dueKey(), windowKey)
+		return nil, err
+	}
+
+	if _, err = redis.Do("SET", windowKey, 1, "EX", window); err != nil {
+		return nil, err
+	}
+	if _, err = redis.Do("EXPIRE", countKey, window*2); err != nil {
+		return nil, err
+	}
+	if _, err = redis.Do("ZADD", this.dueKey(), time.Now().Unix()+window, windowKey); err != nil {
+		return nil, err
+	}
+
+	return &AlarmDigest{Alarm: []byte(last["alarm"]), Occurrences: count}, nil
+}
+
+// 清除去重记录，报警恢复后再次报警时立即发送，返回清除前被抑制的报警次数
+func (this *AlarmDedup) Reset(jobId int64, serviceName, rule string) (int64, error) {
+	windowKey := this.cacheKey(jobId, serviceName, rule)
+	reply, err := redis.Do("GET", windowKey+":count")
+	if err != nil {
+		return 0, err
+	}
+	if _, err = redis.Do("DEL", windowKey, windowKey+":count", windowKey+":last"); err != nil {
+		return 0, err
+	}
+	if _, err = redis.Do("ZREM", this.dueKey(), windowKey); err != nil {
+		return 0, err
+	}
+
+	return parseCount(reply), nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *AlarmDedup) cacheKey(jobId int64, serviceName, rule string) string {
+	return fmt.Sprintf("%s:%d#%s#%s", RDS_REPORT_STATE_DEDUP, jobId, serviceName, rule)
+}
+
+func (this *AlarmDedup) dueKey() string {
+	return RDS_REPORT_STATE_DEDUP + ":due"
+}
+
+func parseCount(reply interface{}) int64 {
+	var count int64
+	if value, ok := reply.([]byte); ok {
+		count, _ = strconv.ParseInt(string(value), 10, 64)
+	}
+	return count
+}
@@ -0,0 +1,135 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"state_monitor/model/redis"
+)
+
+// 报警状态机：每个服务的每条规则独立维护状态，ok（无记录） -> firing（写入记录） -> resolved（删除记录并发送恢复消息，回到 ok）
+// 状态转换依赖 HSETNX/HDEL 的原子性，多个消费者并发处理同一服务时只有一个能完成转换
+type AlarmState struct{}
+
+type AlarmStateRecord struct {
+	Rule       string `json:"rule"`        // 报警规则
+	FiringTime int64  `json:"firing_time"` // 开始报警的时间戳
+	Content    string `json:"content"`     // 开始报警时的内容
+	Severity   string `json:"severity"`    // 开始报警时的级别
+	Escalated  bool   `json:"escalated"`   // 是否已升级
+}
+
+// 仅当规则仍处于同一次报警（firing_time 相同）且未升级时更新记录：并发的升级只有一个成功，
+// 也不会覆盖期间已恢复或已恢复后重新报警的记录
+const escalate_script = `local value = redis.call('HGET', KEYS[1], ARGV[1]) ` +
+	`if not value then return 0 end ` +
+	`local record = cjson.decode(value) ` +
+	`if record['escalated'] or tonumber(record['firing_time']) ~= tonumber(ARGV[2]) then return 0 end ` +
+	`redis.call('HSET', KEYS[1], ARGV[1], ARGV[3]) return 1`
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewAlarmState() *AlarmState {
+	return &AlarmState{}
+}
+
+// 将规则置为报警中，返回是否由 ok 转换为 firing
+func (this *AlarmState) Fire(jobId int64, serviceName string, record *AlarmStateRecord) (bool, error) {
+	if record == nil || record.Rule == "" {
+		return false, fmt.Errorf("params error, record is null or rule is empty")
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+
+	return redis.Hsetnx(this.cacheKey(jobId, serviceName), record.Rule, value)
+}
+
+// 将报警中的规则置为已恢复，规则未处于报警中时返回 nil
+func (this *AlarmState) Resolve(jobId int64, serviceName, rule string) (*AlarmStateRecord, error) {
+	cacheKey := this.cacheKey(jobId, serviceName)
+	value, err := redis.Hget(cacheKey, rule)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	reply, err := redis.Do("HDEL", cacheKey, rule)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := reply.(int64); n == 0 {
+		return nil, nil
+	}
+
+	var record AlarmStateRecord
+	if err = json.Unmarshal(value, &record); err != nil {
+		record.Rule = rule
+	}
+
+	return &record, nil
+}
+
+// 获取报警中的规则，规则未处于报警中时返回 nil
+func (this *AlarmState) Get(jobId int64, serviceName, rule string) (*AlarmStateRecord, error) {
+	value, err := redis.Hget(this.cacheKey(jobId, serviceName), rule)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var record AlarmStateRecord
+	if err = json.Unmarshal(value, &record); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// 将报警中的规则标记为已升级，record 为读取到的报警记录，记录已变化或已升级时返回 false
+func (this *AlarmState) Escalate(jobId int64, serviceName string, record *AlarmStateRecord) (bool, error) {
+	if record == nil || record.Rule == "" {
+		return false, fmt.Errorf("params error, record is null or rule is empty")
+	}
+
+	escalated := *record
+	escalated.Escalated = true
+	value, err := json.Marshal(&escalated)
+	if err != nil {
+		return false, err
+	}
+
+	reply, err := redis.Do("EVAL", escalate_script, 1, this.cacheKey(jobId, serviceName),
+		record.Rule, record.FiringTime, value)
+	if err != nil {
+		return false, err
+	}
+	n, _ := reply.(int64)
+
+	return n == 1, nil
+}
+
+// 获取服务所有报警中的规则
+func (this *AlarmState) GetFiring(jobId int64, serviceName string) (map[string]*AlarmStateRecord, error) {
+	ret, err := redis.Hgetall(this.cacheKey(jobId, serviceName))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]*AlarmStateRecord, len(ret))
+	for rule, value := range ret {
+		var record AlarmStateRecord
+		if err = json.Unmarshal([]byte(value), &record); err != nil {
+			record.Rule = rule
+		}
+		records[rule] = &record
+	}
+
+	return records, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *AlarmState) cacheKey(jobId int64, serviceName string) string {
+	return fmt.Sprintf("%s:%d#%s", RDS_REPORT_STATE_ALARM, jobId, serviceName)
+}
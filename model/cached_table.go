@@ -0,0 +1,40 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+
+	"state_monitor/model/redis"
+)
+
+// 读取缓存的表记录：缓存未命中时调用 query 查询数据库，表不存在（Error 1146）时调用 createTable 建表后重新查询，
+// 查询结果缓存 expire 秒。records 为 query 填充的记录切片指针
+func loadCachedTable(cacheKey string, expire int, records interface{}, query func() error,
+	createTable func() error) error {
+
+	// get values from cache
+	if value, err := redis.Get(cacheKey); err == nil && value != "" {
+		if err = json.Unmarshal([]byte(value), records); err == nil {
+			return nil
+		}
+	}
+
+	// get values from mysql
+	err := query()
+	if err != nil && strings.HasPrefix(err.Error(), "Error 1146") {
+		if err = createTable(); err != nil {
+			return err
+		}
+		err = query()
+	}
+	if err != nil {
+		return err
+	}
+
+	// set cache
+	if value, err := json.Marshal(records); err == nil {
+		redis.Do("SET", cacheKey, value, "EX", expire)
+	}
+
+	return nil
+}
@@ -19,12 +19,6 @@ func init() {
 		return
 	}
 
-	DefMonitorFields = map[string]string{
-		"memory":    "20",
-		"status":    "0",
-		"exit_code": "2#3",
-	}
-
 	seelog.Infof("--------------------------------------------------")
 	seelog.Infof("Service Name: %s", service_name)
 	seelog.Infof("Service Version: %s", service_version)
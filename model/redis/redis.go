@@ -37,6 +37,14 @@ func init() {
 	}
 }
 
+// 关闭连接池
+func Close() error {
+	if pool == nil {
+		return nil
+	}
+	return pool.Close()
+}
+
 func GetPool() redis.Conn {
 	return pool.Get()
 }
@@ -90,6 +98,13 @@ func Smembers(key string) ([]string, error) {
 	return redis.Strings(c.Do("SMEMBERS", key))
 }
 
+func Zrangebyscore(key string, min, max interface{}) ([]string, error) {
+	c := pool.Get()
+	defer c.Close()
+
+	return redis.Strings(c.Do("ZRANGEBYSCORE", key, min, max))
+}
+
 func Hget(key, field string) ([]byte, error) {
 	c := pool.Get()
 	defer c.Close()
@@ -109,6 +124,14 @@ func Hset(key, field string, val []byte) (bool, error) {
 	return ok, err
 }
 
+func Hsetnx(key, field string, val []byte) (bool, error) {
+	c := pool.Get()
+	defer c.Close()
+
+	ok, err := redis.Bool(c.Do("HSETNX", key, field, string(val)))
+	return ok, err
+}
+
 func Hdel(key, field string) error {
 	c := pool.Get()
 	defer c.Close()
@@ -174,6 +197,18 @@ func Rpop(key string) ([]byte, error) {
 	return res.([]byte), nil
 }
 
+func Rpoplpush(src, dest string) ([]byte, error) {
+	c := pool.Get()
+	defer c.Close()
+
+	res, err := c.Do("RPOPLPUSH", src, dest)
+	if err != nil || res == nil {
+		return nil, err
+	}
+
+	return res.([]byte), nil
+}
+
 func Brpoplpush(src, dest string, timeout int) ([]byte, error) {
 	c := pool.Get()
 	defer c.Close()
@@ -186,6 +221,14 @@ func Brpoplpush(src, dest string, timeout int) ([]byte, error) {
 	return res.([]byte), nil
 }
 
+// 返回收到消息的订阅者数量
+func Publish(channel string, val []byte) (int, error) {
+	c := pool.Get()
+	defer c.Close()
+
+	return redis.Int(c.Do("PUBLISH", channel, val))
+}
+
 func Do(cmd string, args ...interface{}) (reply interface{}, err error) {
 	c := pool.Get()
 	defer c.Close()
@@ -0,0 +1,191 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// stream 消息
+type StreamEntry struct {
+	ID     string            // 消息ID
+	Fields map[string][]byte // 消息字段
+}
+
+// 消费组中未确认的消息
+type PendingEntry struct {
+	ID        string // 消息ID
+	Consumer  string // 消费者名称
+	Idle      int64  // 距离上次投递的时间，单位毫秒
+	Delivered int64  // 投递次数
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 追加消息，maxLen > 0 时近似裁剪 stream 长度
+func Xadd(key string, maxLen int, fields map[string][]byte) (string, error) {
+	c := pool.Get()
+	defer c.Close()
+
+	args := redis.Args{}.Add(key)
+	if maxLen > 0 {
+		args = args.Add("MAXLEN", "~", maxLen)
+	}
+	args = args.Add("*")
+	for k, v := range fields {
+		args = args.Add(k, v)
+	}
+
+	return redis.String(c.Do("XADD", args...))
+}
+
+// 创建消费组，stream 不存在时自动创建，消费组已存在时忽略
+func XgroupCreate(key, group string) error {
+	c := pool.Get()
+	defer c.Close()
+
+	_, err := c.Do("XGROUP", "CREATE", key, group, "$", "MKSTREAM")
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// 以消费组方式读取消息，id 为 ">" 时读取新消息，为其它 ID 时读取该消费者 ID 大于 id 的未确认消息；
+// block 单位毫秒，0 表示不阻塞
+func XreadGroup(key, group, consumer, id string, count, block int) ([]*StreamEntry, error) {
+	c := pool.Get()
+	defer c.Close()
+
+	args := redis.Args{}.Add("GROUP", group, consumer, "COUNT", count)
+	if block > 0 {
+		args = args.Add("BLOCK", block)
+	}
+	args = args.Add("STREAMS", key, id)
+
+	streams, err := redis.Values(c.Do("XREADGROUP", args...))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*StreamEntry, 0)
+	for _, stream := range streams {
+		values, err := redis.Values(stream, nil)
+		if err != nil || len(values) != 2 {
+			return nil, fmt.Errorf("redigo: unexpected XREADGROUP reply %v", stream)
+		}
+		items, err := parseStreamEntries(values[1])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, items...)
+	}
+
+	return entries, nil
+}
+
+// 确认消息
+func Xack(key, group string, ids ...string) error {
+	c := pool.Get()
+	defer c.Close()
+
+	_, err := c.Do("XACK", redis.Args{}.Add(key, group).AddFlat(ids)...)
+	return err
+}
+
+// 获取消费组中 ID 不小于 start 的未确认消息，start 为 "-" 时从头开始
+func Xpending(key, group, start string, count int) ([]*PendingEntry, error) {
+	c := pool.Get()
+	defer c.Close()
+
+	values, err := redis.Values(c.Do("XPENDING", key, group, start, "+", count))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*PendingEntry, 0, len(values))
+	for _, value := range values {
+		fields, err := redis.Values(value, nil)
+		if err != nil || len(fields) != 4 {
+			return nil, fmt.Errorf("redigo: unexpected XPENDING reply %v", value)
+		}
+		entry := &PendingEntry{}
+		if _, err = redis.Scan(fields, &entry.ID, &entry.Consumer, &entry.Idle, &entry.Delivered); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// 将空闲时间超过 minIdle 毫秒的消息转移给指定消费者，返回转移成功的消息
+func Xclaim(key, group, consumer string, minIdle int64, ids ...string) ([]*StreamEntry, error) {
+	c := pool.Get()
+	defer c.Close()
+
+	reply, err := c.Do("XCLAIM", redis.Args{}.Add(key, group, consumer, minIdle).AddFlat(ids)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseStreamEntries(reply)
+}
+
+// 返回大于 id 的最小消息ID，用于分页读取
+func NextStreamID(id string) string {
+	i := strings.LastIndexByte(id, '-')
+	if i < 0 {
+		return id + "-1"
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return id
+	}
+	return id[:i+1] + strconv.FormatUint(seq+1, 10)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func parseStreamEntries(reply interface{}) ([]*StreamEntry, error) {
+	values, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*StreamEntry, 0, len(values))
+	for _, value := range values {
+		// deleted entries are returned as nil by XCLAIM
+		if value == nil {
+			continue
+		}
+		items, err := redis.Values(value, nil)
+		if err != nil || len(items) != 2 {
+			return nil, fmt.Errorf("redigo: unexpected stream entry %v", value)
+		}
+		id, err := redis.String(items[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := bytesSlice(items[1], nil)
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+
+		entry := &StreamEntry{
+			ID:     id,
+			Fields: make(map[string][]byte, len(fields)/2),
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			entry.Fields[string(fields[i])] = fields[i+1]
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
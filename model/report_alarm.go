@@ -0,0 +1,114 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"state_monitor/config"
+	"state_monitor/model/mysql"
+
+	"github.com/cihub/seelog"
+)
+
+type ReportAlarm struct {
+	mysql.Model
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewReportAlarm() *ReportAlarm {
+	return &ReportAlarm{
+		Model: mysql.Model{
+			TableName: TABLE_REPORT_ALARM_PRE + time.Now().Format("200601"),
+		},
+	}
+}
+
+func (this *ReportAlarm) RollingBatchInsert(columns []string, params []interface{}) (int64, error) {
+	this.TableName = TABLE_REPORT_ALARM_PRE + time.Now().Format("200601")
+	id, err := this.BatchInsert(columns, params)
+	if err != nil {
+		if !strings.HasPrefix(err.Error(), "Error 1146") {
+			return 0, err
+		}
+		if err = this.createTable(); err != nil {
+			return 0, err
+		}
+		if id, err = this.BatchInsert(columns, params); err != nil {
+			return 0, err
+		}
+
+		// delete expired table
+		maxRolls := config.GetConfig().Service.MaxStoreMonths
+		if err = this.rollTables(maxRolls); err != nil {
+			seelog.Errorf("delete expired report_alarm_x table err: %v", err)
+		}
+	}
+
+	return id, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *ReportAlarm) createTable() error {
+	this.TableName = TABLE_REPORT_ALARM_PRE + time.Now().Format("200601")
+	cmd := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` ( "+
+		"`id` bigint(20) NOT NULL AUTO_INCREMENT COMMENT 'id', "+
+		"`job_id` bigint(20) DEFAULT '0' COMMENT '服务ID', "+
+		"`service_name` varchar(127) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '服务名称', "+
+		"`rule` varchar(64) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '报警规则', "+
+		"`status` varchar(16) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '报警状态：firing.报警中、resolved.已恢复', "+
+		"`severity` varchar(16) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '报警级别', "+
+		"`content` text CHARACTER SET utf8 COLLATE utf8_general_ci COMMENT '报警内容', "+
+		"`occurrences` int(11) DEFAULT '0' COMMENT '抑制窗口内累计报警次数', "+
+		"`alarm_time` bigint(20) DEFAULT '0' COMMENT '报警时间戳', "+
+		"`is_silenced` tinyint(1) DEFAULT '0' COMMENT '静默标识：0.未静默、1.已静默（不投递）', "+
+		"`silence_id` bigint(20) DEFAULT '0' COMMENT '命中的静默规则ID', "+
+		"`send_status` tinyint(1) DEFAULT '0' COMMENT '投递状态：0.未投递、1.投递成功、2.投递失败', "+
+		"`send_error` varchar(255) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '投递失败原因', "+
+		"`send_topic` varchar(127) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '投递主题', "+
+		"`send_partition` int(11) DEFAULT '-1' COMMENT '投递分区', "+
+		"`send_offset` bigint(20) DEFAULT '-1' COMMENT '投递偏移量', "+
+		"`create_time` bigint(20) DEFAULT '0' COMMENT '创建时间戳', "+
+		"PRIMARY KEY (`id`), "+
+		"KEY `idx_job_id` (`job_id`) USING BTREE, "+
+		"KEY `idx_service_name` (`service_name`) USING BTREE, "+
+		"KEY `idx_rule` (`rule`) USING BTREE, "+
+		"KEY `idx_alarm_time` (`alarm_time`) USING BTREE"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8;", this.TableName)
+
+	_, err := this.GetDB().Exec(cmd)
+	return err
+}
+
+func (this *ReportAlarm) rollTables(maxRolls uint32) error {
+	if maxRolls == 0 {
+		return nil
+	}
+
+	cmd := fmt.Sprintf("SHOW TABLES LIKE '%s%s'", TABLE_REPORT_ALARM_PRE, "%")
+	threshold, _ := strconv.Atoi(time.Now().AddDate(0, -int(maxRolls), 0).Format("200601"))
+
+	if rows, err := this.GetDB().Query(cmd); err != nil {
+		return err
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var table string
+			if err = rows.Scan(&table); err != nil {
+				return err
+			}
+
+			month, err := strconv.Atoi(table[len(TABLE_REPORT_ALARM_PRE):])
+			if err == nil && month < threshold {
+				if _, err = this.GetDB().Exec("DROP TABLE " + table); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
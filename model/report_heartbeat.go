@@ -0,0 +1,92 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"state_monitor/model/redis"
+)
+
+type ReportHeartbeat struct {
+	cacheKey string
+}
+
+type HeartbeatRecord struct {
+	JobID       int64  `json:"job_id"`       // 服务ID
+	ServiceName string `json:"service_name"` // 服务名称
+	EnvType     int    `json:"env_type"`     // 环境类型
+	Host        string `json:"host"`         // 主机IP
+	HeartTime   int64  `json:"heart_time"`   // 最后一次心跳时间戳
+	Interval    int64  `json:"interval"`     // 期望的心跳间隔，单位秒
+	MissNum     int64  `json:"miss_num"`     // 允许丢失的心跳次数
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewReportHeartbeat() *ReportHeartbeat {
+	return &ReportHeartbeat{
+		cacheKey: RDS_REPORT_STATE_HEARTBEAT,
+	}
+}
+
+// 心跳超时的截止时间戳，超过该时间未上报即判定为心跳丢失
+func (this *HeartbeatRecord) Deadline() int64 {
+	return this.HeartTime + this.Interval*this.MissNum
+}
+
+func (this *ReportHeartbeat) Get(jobId int64, serviceName string) (*HeartbeatRecord, error) {
+	value, err := redis.Hget(this.cacheKey, this.field(jobId, serviceName))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var record HeartbeatRecord
+	if err = json.Unmarshal(value, &record); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func (this *ReportHeartbeat) GetAll() ([]*HeartbeatRecord, error) {
+	ret, err := redis.Hgetall(this.cacheKey)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*HeartbeatRecord, 0, len(ret))
+	for field, value := range ret {
+		var record HeartbeatRecord
+		if err = json.Unmarshal([]byte(value), &record); err != nil {
+			redis.Hdel(this.cacheKey, field)
+			continue
+		}
+		records = append(records, &record)
+	}
+
+	return records, nil
+}
+
+func (this *ReportHeartbeat) Set(record *HeartbeatRecord) error {
+	if record == nil {
+		return fmt.Errorf("params error, record is null")
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = redis.Hset(this.cacheKey, this.field(record.JobID, record.ServiceName), value)
+	return err
+}
+
+func (this *ReportHeartbeat) Delete(jobId int64, serviceName string) error {
+	return redis.Hdel(this.cacheKey, this.field(jobId, serviceName))
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *ReportHeartbeat) field(jobId int64, serviceName string) string {
+	return fmt.Sprintf("%d#%s", jobId, serviceName)
+}
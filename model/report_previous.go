@@ -0,0 +1,39 @@
+package model
+
+import (
+	"fmt"
+
+	"state_monitor/model/redis"
+)
+
+// 服务上一次的上报内容，供规则表达式中的 prev.xxx 使用
+type ReportPrevious struct {
+	cacheKey string
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewReportPrevious() *ReportPrevious {
+	return &ReportPrevious{
+		cacheKey: RDS_REPORT_STATE_PREVIOUS,
+	}
+}
+
+func (this *ReportPrevious) Get(jobId int64, serviceName string) ([]byte, error) {
+	return redis.Hget(this.cacheKey, this.field(jobId, serviceName))
+}
+
+func (this *ReportPrevious) Set(jobId int64, serviceName string, value []byte) error {
+	_, err := redis.Hset(this.cacheKey, this.field(jobId, serviceName), value)
+	return err
+}
+
+func (this *ReportPrevious) Delete(jobId int64, serviceName string) error {
+	return redis.Hdel(this.cacheKey, this.field(jobId, serviceName))
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *ReportPrevious) field(jobId int64, serviceName string) string {
+	return fmt.Sprintf("%d#%s", jobId, serviceName)
+}
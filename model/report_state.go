@@ -30,13 +30,14 @@ func (this *ReportState) RollingBatchInsert(columns []string, params []interface
 	this.TableName = TABLE_REPORT_STATE_PRE + time.Now().Format("200601")
 	id, err := this.BatchInsert(columns, params)
 	if err != nil {
-		if strings.HasPrefix(err.Error(), "Error 1146") {
-			if err = this.createTable(); err != nil {
-				return 0, err
-			}
-			if id, err = this.BatchInsert(columns, params); err != nil {
-				return 0, err
-			}
+		if !strings.HasPrefix(err.Error(), "Error 1146") {
+			return 0, err
+		}
+		if err = this.createTable(); err != nil {
+			return 0, err
+		}
+		if id, err = this.BatchInsert(columns, params); err != nil {
+			return 0, err
 		}
 
 		// delete expired table
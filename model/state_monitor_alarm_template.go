@@ -0,0 +1,122 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"state_monitor/config"
+	"state_monitor/model/mysql"
+
+	"github.com/cihub/seelog"
+)
+
+type StateMonitorAlarmTemplate struct {
+	mysql.Model
+}
+
+// 报警内容模板：name 为空表示默认模板，rule 为空表示所有规则
+type AlarmTemplateRecord struct {
+	Name string `json:"name"` // 模板名称，投递目标通过名称选择模板
+	Rule string `json:"rule"` // 报警规则
+	Text string `json:"text"` // 模板内容（text/template）
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewStateMonitorAlarmTemplate() *StateMonitorAlarmTemplate {
+	return &StateMonitorAlarmTemplate{
+		Model: mysql.Model{
+			TableName: TABLE_STATE_MONITOR_ALARM_TEMPLATE,
+		},
+	}
+}
+
+// 获取所有报警内容模板，名称和规则相同时数据库中的模板覆盖配置文件中的模板
+func (this *StateMonitorAlarmTemplate) GetAll() (map[string]*AlarmTemplateRecord, error) {
+	ret := make(map[string]*AlarmTemplateRecord)
+	for _, record := range this.fromConfig() {
+		ret[AlarmTemplateKey(record.Name, record.Rule)] = record
+	}
+
+	records, err := this.fromTable()
+	for _, record := range records {
+		ret[AlarmTemplateKey(record.Name, record.Rule)] = record
+	}
+
+	return ret, err
+}
+
+func AlarmTemplateKey(name, rule string) string {
+	return name + "#" + rule
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *StateMonitorAlarmTemplate) fromConfig() []*AlarmTemplateRecord {
+	templates := config.GetConfig().AlarmTemplates
+	records := make([]*AlarmTemplateRecord, 0, len(templates))
+	for _, tpl := range templates {
+		records = append(records, &AlarmTemplateRecord{
+			Name: tpl.Name,
+			Rule: tpl.Rule,
+			Text: tpl.Text,
+		})
+	}
+
+	return records
+}
+
+func (this *StateMonitorAlarmTemplate) fromTable() ([]*AlarmTemplateRecord, error) {
+	var records []*AlarmTemplateRecord
+	err := loadCachedTable(RDS_REPORT_STATE_ALARM_TEMPLATE, ALARM_TEMPLATE_CACHE_EXPIRE_TIME, &records,
+		func() (err error) {
+			records, err = this.selectAll()
+			return err
+		}, this.createTable)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (this *StateMonitorAlarmTemplate) selectAll() ([]*AlarmTemplateRecord, error) {
+	rows, err := this.GetDB().Query(
+		fmt.Sprintf("SELECT name, `rule`, content FROM %s", this.TableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*AlarmTemplateRecord, 0)
+	for rows.Next() {
+		var record AlarmTemplateRecord
+		var content mysql.NullString
+		if err = rows.Scan(&record.Name, &record.Rule, &content); err != nil {
+			return nil, err
+		}
+		record.Text = strings.TrimSpace(content.String)
+		if record.Text == "" {
+			seelog.Errorf("empty alarm template [name: %s, rule: %s]", record.Name, record.Rule)
+			continue
+		}
+		records = append(records, &record)
+	}
+
+	return records, rows.Err()
+}
+
+func (this *StateMonitorAlarmTemplate) createTable() error {
+	cmd := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` ( "+
+		"`id` bigint(20) NOT NULL AUTO_INCREMENT COMMENT 'id', "+
+		"`name` varchar(64) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '模板名称，空.默认模板', "+
+		"`rule` varchar(64) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '报警规则，空.所有规则', "+
+		"`content` text CHARACTER SET utf8 COLLATE utf8_general_ci COMMENT '模板内容（text/template）', "+
+		"`create_time` bigint(20) DEFAULT '0' COMMENT '创建时间戳', "+
+		"PRIMARY KEY (`id`), "+
+		"UNIQUE KEY `uk_name_rule` (`name`, `rule`) USING BTREE"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8;", this.TableName)
+
+	_, err := this.GetDB().Exec(cmd)
+	return err
+}
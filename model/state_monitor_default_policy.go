@@ -0,0 +1,165 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"state_monitor/config"
+	"state_monitor/model/mysql"
+
+	"github.com/cihub/seelog"
+)
+
+type StateMonitorDefaultPolicy struct {
+	mysql.Model
+}
+
+// 默认监控策略：env_type 为 -1 表示所有环境，service_prefix 为空表示所有服务
+type DefaultPolicyRecord struct {
+	EnvType       int               `json:"env_type"`       // 环境类型
+	ServicePrefix string            `json:"service_prefix"` // 服务名称前缀
+	Fields        map[string]string `json:"fields"`         // 监控字段
+}
+
+// 没有任何默认策略时使用的内置策略，不检测心跳，由默认策略配置 heart_interval 开启
+var builtinMonitorFields = map[string]string{
+	"memory":    "20",
+	"status":    "0",
+	"exit_code": "2#3",
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewStateMonitorDefaultPolicy() *StateMonitorDefaultPolicy {
+	return &StateMonitorDefaultPolicy{
+		Model: mysql.Model{
+			TableName: TABLE_STATE_MONITOR_DEFAULT_POLICY,
+		},
+	}
+}
+
+// 获取服务的默认监控策略：匹配的策略按 所有环境 < 指定环境、无前缀 < 短前缀 < 长前缀 的顺序逐个覆盖，
+// 相同条件下数据库中的策略覆盖配置文件中的策略
+func (this *StateMonitorDefaultPolicy) GetFields(envType int, serviceName string) (map[string]string, error) {
+	records := this.fromConfig()
+	tableRecords, err := this.fromTable()
+	if err != nil {
+		seelog.Errorf("get default policy from table err: %v", err)
+	}
+	records = append(records, tableRecords...)
+
+	matched := make([]*DefaultPolicyRecord, 0, len(records))
+	for _, record := range records {
+		if record.EnvType >= 0 && record.EnvType != envType {
+			continue
+		}
+		if !strings.HasPrefix(serviceName, record.ServicePrefix) {
+			continue
+		}
+		matched = append(matched, record)
+	}
+	if len(matched) == 0 {
+		return copyFields(builtinMonitorFields), nil
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		if (matched[i].EnvType >= 0) != (matched[j].EnvType >= 0) {
+			return matched[j].EnvType >= 0
+		}
+		return len(matched[i].ServicePrefix) < len(matched[j].ServicePrefix)
+	})
+
+	ret := make(map[string]string)
+	for _, record := range matched {
+		for k, v := range record.Fields {
+			ret[k] = v
+		}
+	}
+
+	return ret, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *StateMonitorDefaultPolicy) fromConfig() []*DefaultPolicyRecord {
+	policies := config.GetConfig().DefaultPolicies
+	records := make([]*DefaultPolicyRecord, 0, len(policies))
+	for _, policy := range policies {
+		record := &DefaultPolicyRecord{
+			EnvType:       policy.EnvType,
+			ServicePrefix: policy.Prefix,
+			Fields:        make(map[string]string, len(policy.Fields)),
+		}
+		for _, field := range policy.Fields {
+			record.Fields[field.Name] = strings.TrimSpace(field.Value)
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func (this *StateMonitorDefaultPolicy) fromTable() ([]*DefaultPolicyRecord, error) {
+	var records []*DefaultPolicyRecord
+	err := loadCachedTable(RDS_REPORT_STATE_DEFAULT_POLICY, DEFAULT_POLICY_CACHE_EXPIRE_TIME, &records,
+		func() (err error) {
+			records, err = this.selectAll()
+			return err
+		}, this.createTable)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (this *StateMonitorDefaultPolicy) selectAll() ([]*DefaultPolicyRecord, error) {
+	rows, err := this.GetDB().Query(
+		fmt.Sprintf("SELECT env_type, service_prefix, fields FROM %s", this.TableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*DefaultPolicyRecord, 0)
+	for rows.Next() {
+		var record DefaultPolicyRecord
+		var fields mysql.NullString
+		if err = rows.Scan(&record.EnvType, &record.ServicePrefix, &fields); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(fields.String), &record.Fields); err != nil {
+			seelog.Errorf("invalid default policy fields [env_type: %d, prefix: %s]: %v",
+				record.EnvType, record.ServicePrefix, err)
+			continue
+		}
+		records = append(records, &record)
+	}
+
+	return records, rows.Err()
+}
+
+func (this *StateMonitorDefaultPolicy) createTable() error {
+	cmd := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` ( "+
+		"`id` bigint(20) NOT NULL AUTO_INCREMENT COMMENT 'id', "+
+		"`env_type` tinyint(1) DEFAULT '-1' COMMENT '环境类型，-1.所有环境', "+
+		"`service_prefix` varchar(127) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '服务名称前缀，空.所有服务', "+
+		"`fields` text CHARACTER SET utf8 COLLATE utf8_general_ci COMMENT '监控字段（JSON）', "+
+		"`create_time` bigint(20) DEFAULT '0' COMMENT '创建时间戳', "+
+		"PRIMARY KEY (`id`), "+
+		"UNIQUE KEY `uk_env_prefix` (`env_type`, `service_prefix`) USING BTREE"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8;", this.TableName)
+
+	_, err := this.GetDB().Exec(cmd)
+	return err
+}
+
+func copyFields(fields map[string]string) map[string]string {
+	ret := make(map[string]string, len(fields))
+	for k, v := range fields {
+		ret[k] = v
+	}
+	return ret
+}
@@ -0,0 +1,121 @@
+package model
+
+import (
+	"fmt"
+	"path"
+
+	"state_monitor/config"
+	"state_monitor/model/mysql"
+)
+
+type StateMonitorOwner struct {
+	mysql.Model
+}
+
+// 服务负责团队：job_id 大于 0 时按服务ID匹配，否则按服务名称通配符匹配
+type OwnerRecord struct {
+	ID             int64    `json:"id"`
+	JobID          int64    `json:"job_id"`          // 服务ID
+	ServicePattern string   `json:"service_pattern"` // 服务名称通配符，例如 order_*
+	Team           string   `json:"team"`            // 负责团队
+	Contacts       []string `json:"contacts"`        // 联系人
+	Channels       []string `json:"channels"`        // 通知渠道
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewStateMonitorOwner() *StateMonitorOwner {
+	return &StateMonitorOwner{
+		Model: mysql.Model{
+			TableName: TABLE_STATE_MONITOR_OWNER,
+		},
+	}
+}
+
+// 负责团队是否匹配服务
+func (this *OwnerRecord) Match(jobId int64, serviceName string) bool {
+	if this.JobID > 0 {
+		return this.JobID == jobId
+	}
+	ok, err := path.Match(this.ServicePattern, serviceName)
+	return err == nil && ok
+}
+
+// 获取服务的负责团队，按服务ID匹配优先，其次为最长的服务名称通配符，没有匹配时返回 nil
+func (this *StateMonitorOwner) Get(jobId int64, serviceName string) (*OwnerRecord, error) {
+	records, err := this.getAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret *OwnerRecord
+	for _, record := range records {
+		if !record.Match(jobId, serviceName) {
+			continue
+		}
+		if record.JobID > 0 {
+			return record, nil
+		}
+		if ret == nil || len(record.ServicePattern) > len(ret.ServicePattern) {
+			ret = record
+		}
+	}
+
+	return ret, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *StateMonitorOwner) getAll() ([]*OwnerRecord, error) {
+	var records []*OwnerRecord
+	err := loadCachedTable(RDS_REPORT_STATE_OWNER, OWNER_CACHE_EXPIRE_TIME, &records, func() (err error) {
+		records, err = this.selectAll()
+		return err
+	}, this.createTable)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (this *StateMonitorOwner) selectAll() ([]*OwnerRecord, error) {
+	rows, err := this.GetDB().Query(
+		fmt.Sprintf("SELECT id, job_id, service_pattern, team, contacts, channels FROM %s", this.TableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*OwnerRecord, 0)
+	for rows.Next() {
+		var record OwnerRecord
+		var contacts, channels mysql.NullString
+		err = rows.Scan(&record.ID, &record.JobID, &record.ServicePattern, &record.Team, &contacts, &channels)
+		if err != nil {
+			return nil, err
+		}
+		record.Contacts = config.SplitList(contacts.String)
+		record.Channels = config.SplitList(channels.String)
+		records = append(records, &record)
+	}
+
+	return records, rows.Err()
+}
+
+func (this *StateMonitorOwner) createTable() error {
+	cmd := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` ( "+
+		"`id` bigint(20) NOT NULL AUTO_INCREMENT COMMENT 'id', "+
+		"`job_id` bigint(20) DEFAULT '0' COMMENT '服务ID，0.按服务名称通配符匹配', "+
+		"`service_pattern` varchar(127) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '服务名称通配符', "+
+		"`team` varchar(64) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '负责团队', "+
+		"`contacts` varchar(255) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '联系人，逗号分隔', "+
+		"`channels` varchar(255) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '通知渠道，逗号分隔', "+
+		"`create_time` bigint(20) DEFAULT '0' COMMENT '创建时间戳', "+
+		"PRIMARY KEY (`id`), "+
+		"KEY `idx_job_id` (`job_id`) USING BTREE"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8;", this.TableName)
+
+	_, err := this.GetDB().Exec(cmd)
+	return err
+}
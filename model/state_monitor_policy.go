@@ -37,7 +37,7 @@ func (this *StateMonitorPolicy) DeleteCache(jobId int64, serviceName string) err
 	return nil
 }
 
-func (this *StateMonitorPolicy) GetFieldsMap(jobId int64, serviceName string) (map[string]string, error) {
+func (this *StateMonitorPolicy) GetFieldsMap(jobId int64, serviceName string, envType int) (map[string]string, error) {
 
 	// get values from cache
 	cacheKey := fmt.Sprintf("%s:%d#%s", RDS_REPORT_STATE_POLICY, jobId, serviceName)
@@ -61,10 +61,11 @@ func (this *StateMonitorPolicy) GetFieldsMap(jobId int64, serviceName string) (m
 			if err.Error() != mysql.ErrNoRows.Error() {
 				return nil, err
 			}
-			ret = DefMonitorFields
 		}
 		if policy == 0 {
-			ret = DefMonitorFields
+			if ret, err = NewStateMonitorDefaultPolicy().GetFields(envType, serviceName); err != nil {
+				return nil, err
+			}
 		} else {
 			if err := json.Unmarshal([]byte(fieldsNullString.String), &ret); err != nil {
 				return nil, err
@@ -72,10 +73,13 @@ func (this *StateMonitorPolicy) GetFieldsMap(jobId int64, serviceName string) (m
 		}
 	}
 
-	// set cache
+	// set cache, policy merged from default policies expires with the default policy cache
 	ret["monitor_policy"] = strconv.Itoa(policy)
 	ret["timestamp"] = strconv.FormatInt(time.Now().Unix(), 10)
 	redis.Hmset(cacheKey, ret)
+	if policy == 0 {
+		redis.Do("EXPIRE", cacheKey, DEFAULT_POLICY_CACHE_EXPIRE_TIME)
+	}
 
 	return ret, nil
 }
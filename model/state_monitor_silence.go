@@ -0,0 +1,121 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"state_monitor/model/mysql"
+)
+
+type StateMonitorSilence struct {
+	mysql.Model
+}
+
+// 静默规则：所有限制条件均匹配时生效，job_id 为 0、service_pattern 和 host 为空、env_type 为 -1 表示不限制
+type SilenceRecord struct {
+	ID             int64  `json:"id"`
+	JobID          int64  `json:"job_id"`          // 服务ID
+	ServicePattern string `json:"service_pattern"` // 服务名称通配符，例如 order_*
+	Host           string `json:"host"`            // 主机IP
+	EnvType        int    `json:"env_type"`        // 环境类型
+	StartTime      int64  `json:"start_time"`      // 开始时间戳
+	EndTime        int64  `json:"end_time"`        // 结束时间戳
+	Comment        string `json:"comment"`         // 备注
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func NewStateMonitorSilence() *StateMonitorSilence {
+	return &StateMonitorSilence{
+		Model: mysql.Model{
+			TableName: TABLE_STATE_MONITOR_SILENCE,
+		},
+	}
+}
+
+// 静默规则是否匹配服务
+func (this *SilenceRecord) Match(jobId int64, serviceName, host string, envType int, now int64) bool {
+	if now < this.StartTime || now >= this.EndTime {
+		return false
+	}
+	if this.JobID > 0 && this.JobID != jobId {
+		return false
+	}
+	if this.ServicePattern != "" {
+		if ok, err := path.Match(this.ServicePattern, serviceName); err != nil || !ok {
+			return false
+		}
+	}
+	if this.Host != "" && this.Host != host {
+		return false
+	}
+	if this.EnvType >= 0 && this.EnvType != envType {
+		return false
+	}
+
+	return true
+}
+
+// 获取未过期的静默规则，优先从缓存读取
+func (this *StateMonitorSilence) GetUnexpired() ([]*SilenceRecord, error) {
+	var records []*SilenceRecord
+	err := loadCachedTable(RDS_REPORT_STATE_SILENCE, SILENCE_CACHE_EXPIRE_TIME, &records, func() (err error) {
+		records, err = this.selectUnexpired()
+		return err
+	}, this.createTable)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (this *StateMonitorSilence) selectUnexpired() ([]*SilenceRecord, error) {
+	exps := map[string]interface{}{
+		"end_time>?": time.Now().Unix(),
+	}
+	query := this.Select("id, job_id, service_pattern, host, env_type, start_time, end_time, comment").
+		Form(this.TableName)
+	rows, err := this.SelectRowsWhere(query, exps)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*SilenceRecord, 0)
+	for rows.Next() {
+		var record SilenceRecord
+		var comment mysql.NullString
+		err = rows.Scan(&record.ID, &record.JobID, &record.ServicePattern, &record.Host, &record.EnvType,
+			&record.StartTime, &record.EndTime, &comment)
+		if err != nil {
+			return nil, err
+		}
+		record.Comment = comment.String
+		records = append(records, &record)
+	}
+
+	return records, rows.Err()
+}
+
+func (this *StateMonitorSilence) createTable() error {
+	cmd := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` ( "+
+		"`id` bigint(20) NOT NULL AUTO_INCREMENT COMMENT 'id', "+
+		"`job_id` bigint(20) DEFAULT '0' COMMENT '服务ID，0.不限制', "+
+		"`service_pattern` varchar(127) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '服务名称通配符，空.不限制', "+
+		"`host` varchar(32) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '主机IP，空.不限制', "+
+		"`env_type` tinyint(1) DEFAULT '-1' COMMENT '环境类型，-1.不限制', "+
+		"`start_time` bigint(20) DEFAULT '0' COMMENT '开始时间戳', "+
+		"`end_time` bigint(20) DEFAULT '0' COMMENT '结束时间戳', "+
+		"`comment` varchar(255) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT '' COMMENT '备注', "+
+		"`create_time` bigint(20) DEFAULT '0' COMMENT '创建时间戳', "+
+		"PRIMARY KEY (`id`), "+
+		"KEY `idx_end_time` (`end_time`) USING BTREE"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8;", this.TableName)
+
+	_, err := this.GetDB().Exec(cmd)
+	return err
+}
@@ -1,9 +1,5 @@
 package model
 
-var (
-	DefMonitorFields map[string]string
-)
-
 const (
 	service_name       = "state_monitor_center"
 	service_version    = "V0.0.1"
@@ -12,14 +8,26 @@ const (
 
 // table name
 const (
-	TABLE_REPORT_STATE_PRE     = "report_state_"        // 上报状态表前缀
-	TABLE_REPORT_ALARM_PRE     = "report_alarm_"        // 上报警告表前缀
-	TABLE_STATE_MONITOR_POLICY = "state_monitor_policy" // 状态接听策略
+	TABLE_REPORT_STATE_PRE             = "report_state_"                // 上报状态表前缀
+	TABLE_REPORT_ALARM_PRE             = "report_alarm_"                // 上报警告表前缀
+	TABLE_STATE_MONITOR_POLICY         = "state_monitor_policy"         // 状态接听策略
+	TABLE_STATE_MONITOR_SILENCE        = "state_monitor_silence"        // 报警静默规则
+	TABLE_STATE_MONITOR_DEFAULT_POLICY = "state_monitor_default_policy" // 默认监控策略
+	TABLE_STATE_MONITOR_ALARM_TEMPLATE = "state_monitor_alarm_template" // 报警内容模板
+	TABLE_STATE_MONITOR_OWNER          = "state_monitor_owner"          // 服务负责团队
 )
 
 // redis key
 const (
-	RDS_REPORT_STATE_POLICY = "monitor:state:policy" // 监控状态策略
+	RDS_REPORT_STATE_POLICY         = "monitor:state:policy"         // 监控状态策略
+	RDS_REPORT_STATE_HEARTBEAT      = "monitor:state:heartbeat"      // 服务心跳记录
+	RDS_REPORT_STATE_ALARM          = "monitor:state:alarm"          // 服务报警状态
+	RDS_REPORT_STATE_DEDUP          = "monitor:state:dedup"          // 服务报警去重
+	RDS_REPORT_STATE_PREVIOUS       = "monitor:state:previous"       // 服务上一次的上报内容
+	RDS_REPORT_STATE_SILENCE        = "monitor:state:silence"        // 未过期的报警静默规则
+	RDS_REPORT_STATE_DEFAULT_POLICY = "monitor:state:default_policy" // 数据库中的默认监控策略
+	RDS_REPORT_STATE_ALARM_TEMPLATE = "monitor:state:alarm_template" // 数据库中的报警内容模板
+	RDS_REPORT_STATE_OWNER          = "monitor:state:owner"          // 服务负责团队
 )
 
 // report_state state
@@ -39,9 +47,83 @@ const (
 	REPORT_STATE_COM_EXIT_CODE_EXIT_KILL   = 3 // 通用字段：kill by admin
 )
 
+// report_alarm state
+const (
+	REPORT_ALARM_SEND_STATUS_NONE    = 0 // 投递状态：未投递
+	REPORT_ALARM_SEND_STATUS_SUCCESS = 1 // 投递状态：投递成功
+	REPORT_ALARM_SEND_STATUS_FAILED  = 2 // 投递状态：投递失败
+
+	REPORT_ALARM_SEND_ERROR_MAX_LEN = 255 // 投递失败原因的最大字符数，与 send_error 字段长度一致
+)
+
+// alarm
+const (
+	ALARM_STATUS_FIRING   = "firing"   // 报警状态：报警中
+	ALARM_STATUS_RESOLVED = "resolved" // 报警状态：已恢复
+
+	ALARM_SEVERITY_INFO     = "info"     // 报警级别：提示
+	ALARM_SEVERITY_WARNING  = "warning"  // 报警级别：警告
+	ALARM_SEVERITY_CRITICAL = "critical" // 报警级别：严重
+
+	ALARM_RULE_MEMORY    = "memory"    // 报警规则：内存占用过高
+	ALARM_RULE_LOAD      = "load"      // 报警规则：机器负载过高
+	ALARM_RULE_NET_IN    = "net_in"    // 报警规则：网络流入速率过高
+	ALARM_RULE_NET_OUT   = "net_out"   // 报警规则：网络流出速率过高
+	ALARM_RULE_STATUS    = "status"    // 报警规则：服务状态异常
+	ALARM_RULE_EXIT_CODE = "exit_code" // 报警规则：服务异常退出
+	ALARM_RULE_HEARTBEAT = "heartbeat" // 报警规则：心跳超时
+)
+
+// dead letter header
+const (
+	DEAD_LETTER_HEADER_TOPIC     = "dl_original_topic"     // 死信消息头：原始主题
+	DEAD_LETTER_HEADER_PARTITION = "dl_original_partition" // 死信消息头：原始分区
+	DEAD_LETTER_HEADER_OFFSET    = "dl_original_offset"    // 死信消息头：原始偏移量
+	DEAD_LETTER_HEADER_ERROR     = "dl_error"              // 死信消息头：处理失败原因
+	DEAD_LETTER_HEADER_TIME      = "dl_time"               // 死信消息头：进入死信主题的时间戳
+)
+
+// alarm header
+const (
+	ALARM_HEADER_RULE           = "rule"           // 报警消息头：报警规则
+	ALARM_HEADER_STATUS         = "status"         // 报警消息头：报警状态
+	ALARM_HEADER_SEVERITY       = "severity"       // 报警消息头：报警级别
+	ALARM_HEADER_ENV_TYPE       = "env_type"       // 报警消息头：环境类型
+	ALARM_HEADER_SCHEMA_VERSION = "schema_version" // 报警消息头：报警 JSON 格式版本
+
+	ALARM_SCHEMA_VERSION = "1" // 报警 JSON 格式版本，字段不兼容变更时递增
+)
+
+// redis stream
+const (
+	REDIS_STREAM_FIELD      = "data" // 上报状态和报警 JSON 所在的消息字段
+	REDIS_STREAM_READ_COUNT = 100    // 每次读取的消息数
+	REDIS_STREAM_BLOCK_TIME = 1000   // 读取新消息的阻塞时间，单位毫秒
+)
+
+// redis list
+const (
+	REDIS_LIST_HEARTBEAT_INTERVAL = 10 // 实例心跳间隔，单位秒
+	REDIS_LIST_INSTANCE_TIMEOUT   = 60 // 实例心跳超时时间，超时后其处理中的消息放回列表，单位秒
+)
+
+// http source
+const (
+	HTTP_MAX_BODY_SIZE  = 4 << 20 // 上报请求体最大字节数（解压后）
+	HTTP_MAX_BATCH_SIZE = 1000    // 单次请求最多上报的状态数
+)
+
 // others
 const (
-	CHAN_CONSUMER_MSG_CAPS     = 100 // 消费者的消息通道容量
-	BATCH_INSERT_CAPS          = 200 // 批量插入最大容量
-	BATCH_INSERT_INTERVAL_TIME = 90  // 单位秒
+	CHAN_CONSUMER_MSG_CAPS           = 100 // 消费者的消息通道容量
+	SOURCE_COMMIT_TIMEOUT            = 5   // 停止时消息来源提交消费进度的最长时间，停止超时后也会提交，单位秒
+	STATE_FLUSH_TIMEOUT              = 10  // 停止时写入剩余上报状态和报警记录的最长时间，停止超时后也会写入，单位秒
+	SHUTDOWN_CUTOFF                  = 10  // 停止超时后等待被放弃的步骤结束的最长时间，之后关闭 MySQL 和 Redis，单位秒
+	CHAN_PRODUCER_RESULT_CAPS        = 100 // kafka 生产者待回调的投递结果通道容量
+	KAFKA_REVOKE_MARGIN              = 500 // 分区被收回时停止标记 offset 与消费者提交 offset 之间的间隔，单位毫秒
+	ALARM_DIGEST_SWEEP_INTERVAL      = 30  // 扫描抑制窗口已结束的报警并发送汇总的间隔，单位秒
+	SILENCE_CACHE_EXPIRE_TIME        = 60  // 静默规则缓存过期时间，单位秒
+	DEFAULT_POLICY_CACHE_EXPIRE_TIME = 60  // 默认监控策略及由其合并的服务策略缓存过期时间，单位秒
+	ALARM_TEMPLATE_CACHE_EXPIRE_TIME = 60  // 报警内容模板缓存过期时间，单位秒
+	OWNER_CACHE_EXPIRE_TIME          = 60  // 服务负责团队缓存过期时间，单位秒
 )
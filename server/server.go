@@ -2,23 +2,30 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"state_monitor/business"
+	"state_monitor/config"
+	"state_monitor/model"
+
+	"github.com/cihub/seelog"
 )
 
 type Server struct {
-	ctx    context.Context
-	cancel context.CancelFunc
-	Kafkas []business.Consumer
+	ctx      context.Context
+	cancel   context.CancelFunc
+	Pipeline *business.Pipeline
+	Sources  []business.Consumer
 }
 
 func NewServer() *Server {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &Server{
-		ctx:    ctx,
-		cancel: cancel,
-		Kafkas: make([]business.Consumer, 0, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+		Sources: make([]business.Consumer, 0, 1),
 	}
 }
 
@@ -27,8 +34,12 @@ func (this *Server) Ctx() context.Context {
 }
 
 func (this *Server) Start() error {
-	for _, v := range this.Kafkas {
-		if err := v.Start(); err != nil {
+	if err := this.Pipeline.Start(this.ctx); err != nil {
+		return err
+	}
+
+	for _, v := range this.Sources {
+		if err := v.Start(this.ctx); err != nil {
 			return err
 		}
 	}
@@ -36,8 +47,54 @@ func (this *Server) Start() error {
 	return nil
 }
 
-func (this *Server) Stop() {
-	for _, v := range this.Kafkas {
-		v.Stop()
+// 在 shutdown_timeout 秒内按顺序停止：消息来源停止接收 -> 流水线处理协程退出 -> 报警投递完成，超时时报告被放弃的工作；
+// 之后总是在 STATE_FLUSH_TIMEOUT 秒内将上报状态和报警记录写入 MySQL，在 SOURCE_COMMIT_TIMEOUT 秒内提交消息来源
+// 已完成消息的消费进度，未提交的消息下次启动时重新消费。被放弃的步骤最多再等待 SHUTDOWN_CUTOFF 秒，
+// 之后才在进程退出时关闭 MySQL 和 Redis
+func (this *Server) Stop() bool {
+	timeout := time.Duration(config.GetConfig().Service.ShutdownTimeout) * time.Second
+	coordinator := newShutdownCoordinator(timeout, model.SHUTDOWN_CUTOFF*time.Second, this.backlog)
+
+	coordinator.add("stop sources", func(ctx context.Context) {
+		for _, v := range this.Sources {
+			if err := v.Stop(ctx); err != nil {
+				seelog.Errorf("stop source err: %v", err)
+			}
+		}
+	})
+	coordinator.add("drain pipeline workers", func(ctx context.Context) {
+		this.Pipeline.Drain()
+	})
+	coordinator.add("wait alarm deliveries", func(ctx context.Context) {
+		this.Pipeline.CloseSinks()
+	})
+	coordinator.addFinal("flush mysql writers", model.STATE_FLUSH_TIMEOUT*time.Second, func(ctx context.Context) {
+		this.Pipeline.CloseWriter()
+	})
+	coordinator.addFinal("commit sources", model.SOURCE_COMMIT_TIMEOUT*time.Second, func(ctx context.Context) {
+		for _, v := range this.Sources {
+			if err := v.Close(ctx); err != nil {
+				seelog.Errorf("close source err: %v", err)
+			}
+		}
+	})
+	ok := coordinator.run()
+
+	// 结束所有绑定服务 context 的后台协程
+	this.cancel()
+
+	return ok
+}
+
+// 未完成的工作
+func (this *Server) backlog() string {
+	pending := 0
+	for _, v := range this.Sources {
+		pending += v.Pending()
 	}
+	backlog := this.Pipeline.Backlog()
+
+	return fmt.Sprintf("%d source msgs unfinished, %d msgs unprocessed, %d state rows unwritten, "+
+		"%d alarms undelivered, %d alarm records unwritten", pending, backlog.Msgs, backlog.Rows, backlog.Alarms,
+		backlog.Records)
 }
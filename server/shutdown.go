@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+)
+
+// 停止步骤，run 在 ctx 结束前未返回时视为超时，后续步骤不再执行
+type shutdownStep struct {
+	name    string
+	run     func(ctx context.Context)
+	timeout time.Duration // 最终步骤的超时时间
+}
+
+// 停止协调器：在截止时间内按顺序执行停止步骤，超时时报告被放弃的步骤和未完成的工作；
+// 最终步骤在普通步骤完成或超时后总是执行，各自使用单独的超时时间。被放弃的步骤仍在运行，
+// 返回前最多等待 cutoff，避免调用方在它们结束前释放它们使用的资源（例如关闭 MySQL 和 Redis）
+type shutdownCoordinator struct {
+	timeout time.Duration  // 停止截止时间
+	cutoff  time.Duration  // 等待被放弃的步骤结束的最长时间
+	steps   []shutdownStep // 停止步骤
+	finals  []shutdownStep // 最终步骤
+	report  func() string  // 未完成的工作
+	running sync.WaitGroup // 等待所有步骤的协程结束
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newShutdownCoordinator(timeout, cutoff time.Duration, report func() string) *shutdownCoordinator {
+	return &shutdownCoordinator{
+		timeout: timeout,
+		cutoff:  cutoff,
+		steps:   make([]shutdownStep, 0, 8),
+		report:  report,
+	}
+}
+
+func (this *shutdownCoordinator) add(name string, run func(ctx context.Context)) {
+	this.steps = append(this.steps, shutdownStep{name: name, run: run})
+}
+
+// 添加最终步骤，即使停止截止时间已过也会执行，run 最多等待 timeout
+func (this *shutdownCoordinator) addFinal(name string, timeout time.Duration, run func(ctx context.Context)) {
+	this.finals = append(this.finals, shutdownStep{name: name, run: run, timeout: timeout})
+}
+
+// 执行所有停止步骤，全部在截止时间内完成时返回 true，返回时所有步骤均已结束或已等待 cutoff
+func (this *shutdownCoordinator) run() bool {
+	begin := time.Now()
+	ok := this.runSteps()
+
+	for _, step := range this.finals {
+		ctx, cancel := context.WithTimeout(context.Background(), step.timeout)
+		if !this.runStep(ctx, step) {
+			seelog.Errorf("shutdown final step %q exceeded %v, unfinished work: %s", step.name, step.timeout,
+				this.report())
+			ok = false
+		}
+		cancel()
+	}
+
+	if ok {
+		seelog.Infof("shutdown done in %v", time.Since(begin))
+		return true
+	}
+
+	if !this.waitAbandoned() {
+		seelog.Errorf("shutdown abandoned steps are still running after %v, unfinished work: %s", this.cutoff,
+			this.report())
+	}
+	return false
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 在截止时间内按顺序执行普通步骤，超时时放弃剩余步骤
+func (this *shutdownCoordinator) runSteps() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), this.timeout)
+	defer cancel()
+
+	for i, step := range this.steps {
+		if !this.runStep(ctx, step) {
+			abandoned := make([]string, 0, len(this.steps)-i)
+			for _, s := range this.steps[i:] {
+				abandoned = append(abandoned, s.name)
+			}
+			seelog.Errorf("shutdown deadline %v exceeded at step %q, abandoned steps: %q, unfinished work: %s",
+				this.timeout, step.name, abandoned, this.report())
+			return false
+		}
+	}
+
+	return true
+}
+
+// 执行一个步骤，ctx 结束前完成时返回 true，超时的步骤在后台继续运行
+func (this *shutdownCoordinator) runStep(ctx context.Context, step shutdownStep) bool {
+	begin := time.Now()
+	done := make(chan struct{})
+	this.running.Add(1)
+	go func() {
+		defer this.running.Done()
+		defer close(done)
+		step.run(ctx)
+	}()
+
+	select {
+	case <-done:
+		seelog.Infof("shutdown step %q done in %v", step.name, time.Since(begin))
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// 等待被放弃的步骤结束，超过 cutoff 时返回 false
+func (this *shutdownCoordinator) waitAbandoned() bool {
+	done := make(chan struct{})
+	go func() {
+		this.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(this.cutoff):
+		return false
+	}
+}